@@ -1,9 +1,23 @@
 package backend
 
 type Backend interface {
+	// Create writes a new item, it fails with ErrAlreadyExists if the ID is taken.
 	Create(item any) error
+	// CreateBatch writes many new items at once. Existing items are never overwritten, their IDs are
+	// reported in a BatchConflictError after the other items were written. Backends that can fail for some
+	// items only, like MirrorBackend, report them in a BatchFailedError.
+	CreateBatch(items []any) error
 	Read(id string) (any, error)
+	// ReadBatch returns the items that exist for the given ids, missing ids are left out.
+	ReadBatch(ids []string) ([]any, error)
+	// Update replaces an existing item, it fails with ErrNotFound if the item doesn't exist
+	// and ErrVersionConflict if the item's Version is not the stored one. An update that only changes
+	// FetchedAt or Gateway keeps the stored Version.
 	Update(item any) error
 	Delete(id string) error
+	// Scan returns every item whose ID starts with prefix.
 	Scan(prefix string) ([]any, error)
+	// ScanPage returns up to limit items whose ID starts with prefix, starting after cursor.
+	// The returned cursor resumes the scan and is empty once there are no more items.
+	ScanPage(prefix string, cursor string, limit int) ([]any, string, error)
 }
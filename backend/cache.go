@@ -0,0 +1,287 @@
+package backend
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CacheStats are the counters of a CachedBackend.
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Evictions    uint64 `json:"evictions"`
+	Size         int    `json:"size"`
+}
+
+// CachedBackend is a read-through cache in front of a Backend. It keeps up to size items in an LRU,
+// each for at most ttl, and remembers missing items and failed fetches for negativeTTL.
+type CachedBackend struct {
+	backend     Backend
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+// cacheEntry is a single cached item. A negative entry stands for a missing item or a failed fetch.
+type cacheEntry struct {
+	id       string
+	value    any
+	negative bool
+	failed   bool
+	expires  time.Time
+}
+
+// NewCachedBackend creates a new CachedBackend around b.
+func NewCachedBackend(b Backend, size int, ttl time.Duration, negativeTTL time.Duration) *CachedBackend {
+	return &CachedBackend{
+		backend:     b,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		lru:         list.New(),
+		entries:     map[string]*list.Element{},
+	}
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *CachedBackend) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// get returns the live entry for id, counting a hit or a miss.
+func (c *CachedBackend) get(id string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		c.stats.Misses++
+		return cacheEntry{}, false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(el)
+		c.stats.Misses++
+		return cacheEntry{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	if entry.negative {
+		c.stats.NegativeHits++
+	} else {
+		c.stats.Hits++
+	}
+	return *entry, true
+}
+
+// put caches entry, evicting the least recently used entries beyond size.
+func (c *CachedBackend) put(entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[entry.id]; ok {
+		c.remove(el)
+	}
+	c.entries[entry.id] = c.lru.PushFront(&entry)
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops an element, the caller holds mu.
+func (c *CachedBackend) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).id)
+}
+
+// Invalidate drops the cached entry for id, if any.
+func (c *CachedBackend) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		c.remove(el)
+	}
+}
+
+// MarkFailed remembers that fetching the item with the given id failed, for negativeTTL.
+func (c *CachedBackend) MarkFailed(id string) {
+	c.put(cacheEntry{id: id, negative: true, failed: true, expires: time.Now().Add(c.negativeTTL)})
+}
+
+// RecentlyFailed reports whether MarkFailed was called for id within negativeTTL.
+func (c *CachedBackend) RecentlyFailed(id string) bool {
+	entry, ok := c.get(id)
+	return ok && entry.failed
+}
+
+func (c *CachedBackend) Create(item any) error {
+	err := c.backend.Create(item)
+	c.invalidateItem(item)
+	return err
+}
+
+func (c *CachedBackend) CreateBatch(items []any) error {
+	err := c.backend.CreateBatch(items)
+	for _, item := range items {
+		c.invalidateItem(item)
+	}
+	return err
+}
+
+func (c *CachedBackend) Read(id string) (any, error) {
+	if entry, ok := c.get(id); ok {
+		if entry.negative {
+			return nil, ErrNotFound
+		}
+		return entry.value, nil
+	}
+
+	value, err := c.backend.Read(id)
+	if errors.Is(err, ErrNotFound) {
+		c.put(cacheEntry{id: id, negative: true, expires: time.Now().Add(c.negativeTTL)})
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(cacheEntry{id: id, value: value, expires: time.Now().Add(c.ttl)})
+	return value, nil
+}
+
+func (c *CachedBackend) ReadBatch(ids []string) ([]any, error) {
+	items := []any{}
+	misses := []string{}
+	for _, id := range dedupeIDs(ids) {
+		entry, ok := c.get(id)
+		if !ok {
+			misses = append(misses, id)
+			continue
+		}
+		if !entry.negative {
+			items = append(items, entry.value)
+		}
+	}
+
+	if len(misses) == 0 {
+		return items, nil
+	}
+
+	values, err := c.backend.ReadBatch(misses)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	for _, value := range values {
+		id := recordID(value)
+		found[id] = true
+		c.put(cacheEntry{id: id, value: value, expires: time.Now().Add(c.ttl)})
+	}
+	for _, id := range misses {
+		if !found[id] {
+			c.put(cacheEntry{id: id, negative: true, expires: time.Now().Add(c.negativeTTL)})
+		}
+	}
+
+	return append(items, values...), nil
+}
+
+func (c *CachedBackend) Update(item any) error {
+	err := c.backend.Update(item)
+	c.invalidateItem(item)
+	return err
+}
+
+func (c *CachedBackend) Delete(id string) error {
+	err := c.backend.Delete(id)
+	c.Invalidate(id)
+	return err
+}
+
+func (c *CachedBackend) Scan(prefix string) ([]any, error) {
+	return c.backend.Scan(prefix)
+}
+
+func (c *CachedBackend) ScanPage(prefix string, cursor string, limit int) ([]any, string, error) {
+	return c.backend.ScanPage(prefix, cursor, limit)
+}
+
+func (c *CachedBackend) ListVersions(id string) ([]any, error) {
+	history, ok := c.backend.(History)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return history.ListVersions(id)
+}
+
+func (c *CachedBackend) GetVersion(id string, version int64) (any, error) {
+	history, ok := c.backend.(History)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return history.GetVersion(id, version)
+}
+
+func (c *CachedBackend) FindByImageCID(cid string) ([]any, error) {
+	lookup, ok := c.backend.(Lookup)
+	if !ok {
+		return nil, ErrLookupUnsupported
+	}
+	return lookup.FindByImageCID(cid)
+}
+
+func (c *CachedBackend) FindByName(name string) ([]any, error) {
+	lookup, ok := c.backend.(Lookup)
+	if !ok {
+		return nil, ErrLookupUnsupported
+	}
+	return lookup.FindByName(name)
+}
+
+func (c *CachedBackend) FindByAttribute(trait string, value any) ([]any, error) {
+	lookup, ok := c.backend.(Lookup)
+	if !ok {
+		return nil, ErrLookupUnsupported
+	}
+	return lookup.FindByAttribute(trait, value)
+}
+
+// invalidateItem drops the cached entry for a written item, whatever its type.
+func (c *CachedBackend) invalidateItem(item any) {
+	fields, err := fields(item)
+	if err != nil {
+		return
+	}
+	if id, ok := fields["ID"].(string); ok {
+		c.Invalidate(id)
+	}
+}
+
+// recordID returns the ID of a record as returned by Read or Scan.
+func recordID(record any) string {
+	m, ok := record.(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	id, _ := m["ID"].(string)
+	return id
+}
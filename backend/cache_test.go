@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingBackend serves Read from a map and counts the calls that reach it.
+type countingBackend struct {
+	Backend
+	items   map[string]any
+	reads   int
+	batches [][]string
+}
+
+func (b *countingBackend) Read(id string) (any, error) {
+	b.reads++
+	item, ok := b.items[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return item, nil
+}
+
+func (b *countingBackend) ReadBatch(ids []string) ([]any, error) {
+	b.batches = append(b.batches, ids)
+	items := []any{}
+	for _, id := range ids {
+		if item, ok := b.items[id]; ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func TestCachedBackendRead(t *testing.T) {
+	b := &countingBackend{items: map[string]any{
+		"d-a": map[string]any{"ID": "d-a"},
+		"d-b": map[string]any{"ID": "d-b"},
+	}}
+	c := NewCachedBackend(b, 1, time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Read("d-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.reads != 1 {
+		t.Errorf("expected 1 read through, got %d", b.reads)
+	}
+
+	// the cache holds a single entry, so reading d-b evicts d-a
+	_, err := c.Read("d-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Read("d-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.reads != 3 {
+		t.Errorf("expected 3 reads through, got %d", b.reads)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = c.Read("d-missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if b.reads != 4 {
+		t.Errorf("expected missing item to be read through once, got %d reads", b.reads)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.NegativeHits != 1 || stats.Misses != 4 || stats.Evictions != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCachedBackendReadBatchDedupesMisses(t *testing.T) {
+	b := &countingBackend{items: map[string]any{"d-a": map[string]any{"ID": "d-a"}}}
+	c := NewCachedBackend(b, 10, time.Minute, time.Minute)
+
+	items, err := c.ReadBatch([]string{"d-a", "d-missing", "d-a", "d-missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Errorf("expected d-a once, got %v", items)
+	}
+	if len(b.batches) != 1 || len(b.batches[0]) != 2 {
+		t.Errorf("expected one batch with each miss once, got %v", b.batches)
+	}
+}
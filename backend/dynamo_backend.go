@@ -1,7 +1,14 @@
 package backend
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -26,19 +33,242 @@ func NewDynamoDBBackend(tableName string, svc *dynamodb.DynamoDB) (Backend, erro
 	return &DynamoDBBackend{db: svc, tableName: tableName}, nil
 }
 
+// versionAttribute holds the record version used for optimistic concurrency.
+const versionAttribute = "Version"
+
+// Create writes a new item with version 1, it fails with ErrAlreadyExists if the ID is taken.
 func (b *DynamoDBBackend) Create(metadata any) error {
 	av, err := dynamodbattribute.MarshalMap(metadata)
 	if err != nil {
 		return err
 	}
+	setVersion(av, 1)
+	setIndexAttributes(av)
+
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.AttributeNotExists(expression.Name("ID"))).
+		Build()
+	if err != nil {
+		return err
+	}
 
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(b.tableName),
-		Item:      av,
+		TableName:                 aws.String(b.tableName),
+		Item:                      av,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
 	}
 
 	_, err = b.db.PutItem(input)
-	return err
+
+	var conditionFailed *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.writeAttributeIndex(aws.StringValue(av["ID"].S), nil, av)
+}
+
+// batchWriteLimit is the maximum number of items DynamoDB accepts in a single BatchWriteItem request.
+const batchWriteLimit = 25
+
+// batchWriteAttempts is how many times unprocessed items of a BatchWriteItem, or keys of a BatchGetItem,
+// are retried before giving up.
+const batchWriteAttempts = 8
+
+// transactWriteLimit is the maximum number of items DynamoDB accepts in a single TransactWriteItems request.
+const transactWriteLimit = 25
+
+// CreateBatch writes new items with version 1, transactWriteLimit items per transaction. Like Create it never
+// overwrites: items whose ID is taken are left untouched and reported in a BatchConflictError, the others are written.
+func (b *DynamoDBBackend) CreateBatch(metadata []any) error {
+	items := make([]map[string]*dynamodb.AttributeValue, 0, len(metadata))
+	conflicts := []string{}
+	seen := make(map[string]bool, len(metadata))
+	for _, item := range metadata {
+		av, err := dynamodbattribute.MarshalMap(item)
+		if err != nil {
+			return err
+		}
+
+		// a transaction can't write the same item twice, a repeated ID is written once by its first occurrence
+		id := aws.StringValue(av["ID"].S)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		setVersion(av, 1)
+		setIndexAttributes(av)
+		items = append(items, av)
+	}
+
+	for start := 0; start < len(items); start += transactWriteLimit {
+		end := start + transactWriteLimit
+		if end > len(items) {
+			end = len(items)
+		}
+
+		written, taken, err := b.createAll(items[start:end])
+		if err != nil {
+			return err
+		}
+		conflicts = append(conflicts, taken...)
+
+		for _, av := range written {
+			err = b.writeAttributeIndex(aws.StringValue(av["ID"].S), nil, av)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return conflictError(conflicts)
+}
+
+// createAll creates the items in one transaction and returns the written items and the IDs that were taken.
+// An existing item cancels the whole transaction, so taken items are dropped and the others are tried again.
+func (b *DynamoDBBackend) createAll(items []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, []string, error) {
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.AttributeNotExists(expression.Name("ID"))).
+		Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	taken := []string{}
+	backoff := 50 * time.Millisecond
+	for attempt := 0; len(items) > 0; attempt++ {
+		if attempt == batchWriteAttempts {
+			return nil, nil, fmt.Errorf("%d items still not created after %d attempts", len(items), attempt)
+		}
+
+		transactItems := make([]*dynamodb.TransactWriteItem, 0, len(items))
+		for _, av := range items {
+			transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+				Put: &dynamodb.Put{
+					TableName:                aws.String(b.tableName),
+					Item:                     av,
+					ConditionExpression:      expr.Condition(),
+					ExpressionAttributeNames: expr.Names(),
+				},
+			})
+		}
+
+		_, err = b.db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: transactItems,
+		})
+		if err == nil {
+			return items, taken, nil
+		}
+
+		var canceled *dynamodb.TransactionCanceledException
+		if !errors.As(err, &canceled) || len(canceled.CancellationReasons) != len(items) {
+			return nil, nil, err
+		}
+
+		// the cancellation reasons line up with the items
+		remaining := make([]map[string]*dynamodb.AttributeValue, 0, len(items))
+		for i, reason := range canceled.CancellationReasons {
+			if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+				taken = append(taken, aws.StringValue(items[i]["ID"].S))
+			} else {
+				remaining = append(remaining, items[i])
+			}
+		}
+
+		// nothing was taken, the transaction was canceled because of throttling or a concurrent write
+		if len(remaining) == len(items) {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		items = remaining
+	}
+
+	return items, taken, nil
+}
+
+// batchWrite sends the write requests with BatchWriteItem in chunks of batchWriteLimit.
+func (b *DynamoDBBackend) batchWrite(requests []*dynamodb.WriteRequest) error {
+	requests = dedupeWriteRequests(requests)
+	for start := 0; start < len(requests); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		requestItems := map[string][]*dynamodb.WriteRequest{
+			b.tableName: requests[start:end],
+		}
+
+		// retry unprocessed items with a growing delay, DynamoDB returns them when throttling
+		backoff := 50 * time.Millisecond
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt == batchWriteAttempts {
+				return fmt.Errorf("%d items still unprocessed after %d attempts", len(requestItems[b.tableName]), attempt)
+			}
+
+			result, err := b.db.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return err
+			}
+
+			requestItems = result.UnprocessedItems
+			if len(requestItems) > 0 {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+	}
+
+	return nil
+}
+
+// dedupeWriteRequests keeps only the last request for every ID, BatchWriteItem rejects requests
+// that write the same item twice.
+func dedupeWriteRequests(requests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+	last := make(map[string]int, len(requests))
+	for i, request := range requests {
+		last[writeRequestID(request)] = i
+	}
+	if len(last) == len(requests) {
+		return requests
+	}
+
+	deduped := make([]*dynamodb.WriteRequest, 0, len(last))
+	for i, request := range requests {
+		if last[writeRequestID(request)] == i {
+			deduped = append(deduped, request)
+		}
+	}
+	return deduped
+}
+
+// dedupeIDs returns ids without repeats, in order of their first occurrence.
+func dedupeIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	deduped := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			deduped = append(deduped, id)
+		}
+	}
+	return deduped
+}
+
+// writeRequestID returns the ID of the item a write request puts or deletes.
+func writeRequestID(request *dynamodb.WriteRequest) string {
+	if request.PutRequest != nil {
+		return aws.StringValue(request.PutRequest.Item["ID"].S)
+	}
+	return aws.StringValue(request.DeleteRequest.Key["ID"].S)
 }
 
 func (b *DynamoDBBackend) Read(id string) (any, error) {
@@ -57,7 +287,7 @@ func (b *DynamoDBBackend) Read(id string) (any, error) {
 	}
 
 	if result.Item == nil {
-		return nil, errors.New("item not found")
+		return nil, ErrNotFound
 	}
 
 	var metadata any
@@ -69,19 +299,261 @@ func (b *DynamoDBBackend) Read(id string) (any, error) {
 	return metadata, nil
 }
 
+// batchGetLimit is the maximum number of keys DynamoDB accepts in a single BatchGetItem request.
+const batchGetLimit = 100
+
+// ReadBatch reads the items with the given IDs, missing items are left out. BatchGetItem rejects repeated keys,
+// so repeated IDs are read once.
+func (b *DynamoDBBackend) ReadBatch(ids []string) ([]any, error) {
+	var items []any
+
+	ids = dedupeIDs(ids)
+	for start := 0; start < len(ids); start += batchGetLimit {
+		end := start + batchGetLimit
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		keys := make([]map[string]*dynamodb.AttributeValue, 0, end-start)
+		for _, id := range ids[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"ID": {S: aws.String(id)},
+			})
+		}
+
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			b.tableName: {Keys: keys},
+		}
+
+		// retry unprocessed keys with a growing delay, DynamoDB returns them when throttling
+		backoff := 50 * time.Millisecond
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt == batchWriteAttempts {
+				return nil, fmt.Errorf("%d keys still unprocessed after %d attempts", len(requestItems[b.tableName].Keys), attempt)
+			}
+
+			result, err := b.db.BatchGetItem(&dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			var page []any
+			err = dynamodbattribute.UnmarshalListOfMaps(result.Responses[b.tableName], &page)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, page...)
+
+			requestItems = result.UnprocessedKeys
+			if len(requestItems) > 0 {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// Update replaces an existing item. The item's Version must match the stored one, on success the stored
+// Version is incremented. It fails with ErrNotFound if the item doesn't exist and ErrVersionConflict if
+// someone else wrote it in the meantime. The replaced version is kept as a history item, see ListVersions.
+// An update that only changes when and where the item was fetched keeps the Version and adds no history item.
 func (b *DynamoDBBackend) Update(metadata any) error {
 	av, err := dynamodbattribute.MarshalMap(metadata)
 	if err != nil {
 		return err
 	}
+	if av["ID"] == nil || av["ID"].S == nil {
+		return errors.New("item has no ID")
+	}
+	id := *av["ID"].S
+	version := getVersion(av)
+	setIndexAttributes(av)
 
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(b.tableName),
-		Item:      av,
+	// the current item becomes the history item, so read it first
+	current, err := b.db.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(b.tableName),
+		Key:            map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(id)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	if current.Item == nil {
+		return ErrNotFound
+	}
+	if getVersion(current.Item) != version {
+		return ErrVersionConflict
 	}
 
-	_, err = b.db.PutItem(input)
-	return err
+	changed, err := contentChanged(current.Item, av)
+	if err != nil {
+		return err
+	}
+
+	transactItems := []*dynamodb.TransactWriteItem{}
+	if changed {
+		setVersion(av, version+1)
+
+		history := make(map[string]*dynamodb.AttributeValue, len(current.Item)+1)
+		for k, v := range current.Item {
+			history[k] = v
+		}
+		history["ID"] = &dynamodb.AttributeValue{S: aws.String(HistoryID(id, version))}
+		history["RecordID"] = &dynamodb.AttributeValue{S: aws.String(id)}
+		// history items must not show up in the secondary indexes
+		for _, name := range indexAttributes {
+			delete(history, name)
+		}
+
+		transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: aws.String(b.tableName),
+				Item:      history,
+			},
+		})
+	} else {
+		setVersion(av, version)
+	}
+
+	// records written before versioning have no Version attribute, they count as version 0
+	versionCond := expression.Equal(expression.Name(versionAttribute), expression.Value(version))
+	if version == 0 {
+		versionCond = expression.Or(versionCond, expression.AttributeNotExists(expression.Name(versionAttribute)))
+	}
+
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.And(expression.AttributeExists(expression.Name("ID")), versionCond)).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	// the item is the last one of the transaction
+	itemIndex := len(transactItems)
+	transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName:                           aws.String(b.tableName),
+			Item:                                av,
+			ConditionExpression:                 expr.Condition(),
+			ExpressionAttributeNames:            expr.Names(),
+			ExpressionAttributeValues:           expr.Values(),
+			ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+		},
+	})
+
+	_, err = b.db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+
+	var canceled *dynamodb.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > itemIndex &&
+		aws.StringValue(canceled.CancellationReasons[itemIndex].Code) == "ConditionalCheckFailed" {
+		if canceled.CancellationReasons[itemIndex].Item == nil {
+			return ErrNotFound
+		}
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.writeAttributeIndex(id, current.Item, av)
+}
+
+// contentChanged reports whether the marshalled items differ in more than when and where they were fetched.
+// Both are unmarshalled the same way, so empty attributes compare equal.
+func contentChanged(current, updated map[string]*dynamodb.AttributeValue) (bool, error) {
+	var currentFields, updatedFields map[string]any
+	err := dynamodbattribute.UnmarshalMap(current, &currentFields)
+	if err != nil {
+		return false, err
+	}
+	err = dynamodbattribute.UnmarshalMap(updated, &updatedFields)
+	if err != nil {
+		return false, err
+	}
+
+	same, err := sameContent(currentFields, updatedFields)
+	return !same, err
+}
+
+// ListVersions returns every kept version of the item with the given id, oldest first.
+// The last entry is the current item.
+func (b *DynamoDBBackend) ListVersions(id string) ([]any, error) {
+	// the attribute index items of the record share its RecordID
+	entries, err := b.queryIndex(RecordIDIndex, "RecordID", id)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]any, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(recordID(entry), HistoryIDPrefix(id)) {
+			history = append(history, entry)
+		}
+	}
+
+	current, err := b.Read(id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if current != nil {
+		history = append(history, current)
+	}
+
+	// the index doesn't guarantee any order
+	sort.SliceStable(history, func(i, j int) bool {
+		return recordVersion(history[i]) < recordVersion(history[j])
+	})
+
+	return history, nil
+}
+
+// GetVersion returns the given version of the item with the given id, or ErrNotFound.
+func (b *DynamoDBBackend) GetVersion(id string, version int64) (any, error) {
+	current, err := b.Read(id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if current != nil && recordVersion(current) == version {
+		return current, nil
+	}
+
+	return b.Read(HistoryID(id, version))
+}
+
+// recordVersion returns the Version of a record as returned by Read or Scan.
+func recordVersion(record any) int64 {
+	m, ok := record.(map[string]any)
+	if !ok {
+		return 0
+	}
+
+	v, _ := m[versionAttribute].(float64)
+	return int64(v)
+}
+
+// getVersion returns the Version attribute of a marshalled item, or 0 if it has none.
+func getVersion(av map[string]*dynamodb.AttributeValue) int64 {
+	v, ok := av[versionAttribute]
+	if !ok || v.N == nil {
+		return 0
+	}
+
+	version, err := strconv.ParseInt(*v.N, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return version
+}
+
+// setVersion sets the Version attribute of a marshalled item.
+func setVersion(av map[string]*dynamodb.AttributeValue, version int64) {
+	av[versionAttribute] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(version, 10))}
 }
 
 func (b *DynamoDBBackend) Delete(id string) error {
@@ -92,39 +564,78 @@ func (b *DynamoDBBackend) Delete(id string) error {
 				S: aws.String(id),
 			},
 		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	}
+
+	result, err := b.db.DeleteItem(input)
+	if err != nil {
+		return err
 	}
 
-	_, err := b.db.DeleteItem(input)
-	return err
+	return b.writeAttributeIndex(id, result.Attributes, nil)
 }
+
+// scanPageSize is the page size Scan uses when walking the whole keyspace.
+const scanPageSize = 100
+
+// Scan returns every item whose ID starts with prefix. Prefer ScanPage for large keyspaces.
 func (b *DynamoDBBackend) Scan(prefix string) ([]any, error) {
 	var items []any
 
+	cursor := ""
+	for {
+		page, next, err := b.ScanPage(prefix, cursor, scanPageSize)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	return items, nil
+}
+
+// ScanPage returns up to limit items whose ID starts with prefix, starting after cursor.
+// The returned cursor resumes the scan and is empty once the keyspace is exhausted.
+func (b *DynamoDBBackend) ScanPage(prefix string, cursor string, limit int) ([]any, string, error) {
+	items := []any{}
+
 	expr, err := expression.NewBuilder().WithFilter(expression.BeginsWith(expression.Name("ID"), prefix)).Build()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	var lastEvaluatedKey map[string]*dynamodb.AttributeValue
-	for {
+	lastEvaluatedKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// DynamoDB applies Limit before the filter, so asking for the remaining count never overshoots limit
+	for len(items) < limit {
 		input := &dynamodb.ScanInput{
 			TableName:                 aws.String(b.tableName),
 			FilterExpression:          expr.Filter(),
 			ExpressionAttributeNames:  expr.Names(),
 			ExpressionAttributeValues: expr.Values(),
-			Limit:                     aws.Int64(100),
+			Limit:                     aws.Int64(int64(limit - len(items))),
 			ExclusiveStartKey:         lastEvaluatedKey,
 		}
 
 		result, err := b.db.Scan(input)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
-		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
+		var page []any
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &page)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
+		items = append(items, page...)
 
 		lastEvaluatedKey = result.LastEvaluatedKey
 		if lastEvaluatedKey == nil {
@@ -132,5 +643,50 @@ func (b *DynamoDBBackend) Scan(prefix string) ([]any, error) {
 		}
 	}
 
-	return items, nil
+	next, err := encodeCursor(lastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return items, next, nil
+}
+
+// encodeCursor turns a LastEvaluatedKey into an opaque continuation token.
+func encodeCursor(key map[string]*dynamodb.AttributeValue) (string, error) {
+	if key == nil {
+		return "", nil
+	}
+
+	var m map[string]any
+	err := dynamodbattribute.UnmarshalMap(key, &m)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor turns a continuation token back into an ExclusiveStartKey.
+func decodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	var m map[string]any
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return dynamodbattribute.MarshalMap(m)
 }
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/ipfs-scrape/worker/ipfs"
+)
+
+// newTestDynamoDBBackend creates a backend on a new table on the DynamoDB compatible server in
+// IPFS_DYNAMODB_TEST_ENDPOINT, e.g. DynamoDB Local.
+func newTestDynamoDBBackend(t *testing.T) Backend {
+	endpoint := os.Getenv("IPFS_DYNAMODB_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("IPFS_DYNAMODB_TEST_ENDPOINT not set")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := dynamodb.New(sess)
+
+	tableName := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	_, err = svc.CreateTable(&dynamodb.CreateTableInput{
+		TableName:   aws.String(tableName),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("ID"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("ID"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(tableName)}) })
+
+	b, err := NewDynamoDBBackend(tableName, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDedupeWriteRequests(t *testing.T) {
+	put := func(id, value string) *dynamodb.WriteRequest {
+		return &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: map[string]*dynamodb.AttributeValue{
+			"ID":    {S: aws.String(id)},
+			"Value": {S: aws.String(value)},
+		}}}
+	}
+	del := func(id string) *dynamodb.WriteRequest {
+		return &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: map[string]*dynamodb.AttributeValue{
+			"ID": {S: aws.String(id)},
+		}}}
+	}
+
+	deduped := dedupeWriteRequests([]*dynamodb.WriteRequest{put("a", "1"), del("b"), put("a", "2"), put("b", "3")})
+	if len(deduped) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(deduped))
+	}
+	if value := aws.StringValue(deduped[0].PutRequest.Item["Value"].S); writeRequestID(deduped[0]) != "a" || value != "2" {
+		t.Errorf("expected the last put of a, got %s=%s", writeRequestID(deduped[0]), value)
+	}
+	if deduped[1].PutRequest == nil || writeRequestID(deduped[1]) != "b" {
+		t.Errorf("expected the put of b to replace its delete, got %+v", deduped[1])
+	}
+}
+
+func TestDedupeIDs(t *testing.T) {
+	deduped := dedupeIDs([]string{"a", "b", "a", "c", "b"})
+	if strings.Join(deduped, ",") != "a,b,c" {
+		t.Errorf("expected a,b,c, got %v", deduped)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := map[string]*dynamodb.AttributeValue{"ID": {S: aws.String("metadata-cid")}}
+	cursor, err := encodeCursor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(decoded["ID"].S) != "metadata-cid" {
+		t.Errorf("expected the key back, got %v", decoded)
+	}
+
+	// the end of the scan has no cursor, and no cursor starts from the beginning
+	cursor, err = encodeCursor(nil)
+	if err != nil || cursor != "" {
+		t.Errorf("expected an empty cursor, got %q and %v", cursor, err)
+	}
+	decoded, err = decodeCursor("")
+	if err != nil || decoded != nil {
+		t.Errorf("expected no start key, got %v and %v", decoded, err)
+	}
+
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24"} {
+		_, err = decodeCursor(cursor)
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid cursor") {
+			t.Errorf("expected an invalid cursor error for %q, got %v", cursor, err)
+		}
+	}
+}
+
+func TestDynamoDBBackendScanPage(t *testing.T) {
+	testScanPage(t, newTestDynamoDBBackend(t))
+}
+
+// testScanPage pages through three records two at a time, ScanPage makes no promise about their order.
+func testScanPage(t *testing.T, b Backend) {
+	store := NewStore[ipfs.Metadata](b)
+	for _, cid := range []string{"a", "b", "c"} {
+		err := store.Put(ipfs.Metadata{ID: ipfs.GenerateIDFromCID(cid), CID: cid})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[string]bool{}
+	cursor := ""
+	for page := 0; page == 0 || cursor != ""; page++ {
+		if page == 2 {
+			t.Fatalf("expected the scan to end after two pages, got cursor %q", cursor)
+		}
+
+		var items []ipfs.Metadata
+		var err error
+		items, cursor, err = store.ListPage(ipfs.MetadataIDPrefix, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page == 0 && (len(items) != 2 || cursor == "") {
+			t.Fatalf("expected a full first page and a cursor, got %d items and %q", len(items), cursor)
+		}
+		for _, item := range items {
+			if seen[item.ID] {
+				t.Errorf("%s returned twice", item.ID)
+			}
+			seen[item.ID] = true
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected every record once, got %v", seen)
+	}
+
+	_, _, err := store.ListPage(ipfs.MetadataIDPrefix, "not base64!", 2)
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid cursor") {
+		t.Errorf("expected an invalid cursor error, got %v", err)
+	}
+}
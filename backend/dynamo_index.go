@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/ipfs-scrape/worker/ipfs"
+)
+
+// Names of the global secondary indexes the lookups query. The table needs them with a projection of ALL.
+const (
+	// ImageCIDIndex is keyed on the ImageCID attribute of records.
+	ImageCIDIndex = "ImageCID-index"
+	// NameKeyIndex is keyed on the NameKey attribute of records.
+	NameKeyIndex = "NameKey-index"
+	// AttrKeyIndex is keyed on the AttrKey attribute of attribute index items.
+	AttrKeyIndex = "AttrKey-index"
+	// RecordIDIndex is keyed on the RecordID attribute of history and attribute index items.
+	RecordIDIndex = "RecordID-index"
+)
+
+// indexAttributes are the attributes maintained on records for the secondary indexes.
+var indexAttributes = []string{"ImageCID", "NameKey"}
+
+// setIndexAttributes derives the ImageCID and NameKey attributes of a marshalled record.
+func setIndexAttributes(av map[string]*dynamodb.AttributeValue) {
+	for _, name := range indexAttributes {
+		delete(av, name)
+	}
+
+	if image := av["Image"]; image != nil && image.S != nil {
+		if cid := ipfs.ImageCID(*image.S); cid != "" {
+			av["ImageCID"] = &dynamodb.AttributeValue{S: aws.String(cid)}
+		}
+	}
+
+	if name := av["Name"]; name != nil && name.S != nil {
+		if key := NormalizeName(*name.S); key != "" {
+			av["NameKey"] = &dynamodb.AttributeValue{S: aws.String(key)}
+		}
+	}
+}
+
+// attributeKeys returns the AttributeKey of every trait of a marshalled record.
+func attributeKeys(av map[string]*dynamodb.AttributeValue) []string {
+	attributes := av["Attributes"]
+	if attributes == nil {
+		return nil
+	}
+
+	keys := []string{}
+	for _, attribute := range attributes.L {
+		trait := attribute.M["trait_type"]
+		value := attribute.M["value"]
+		if trait == nil || trait.S == nil || value == nil {
+			continue
+		}
+
+		var v any
+		err := dynamodbattribute.Unmarshal(value, &v)
+		if err != nil || v == nil {
+			continue
+		}
+		keys = append(keys, AttributeKey(*trait.S, v))
+	}
+
+	return keys
+}
+
+// attributeIndexID returns the ID of the attribute index item for a trait of a record.
+func attributeIndexID(id string, key string) string {
+	return fmt.Sprintf("x-%s-%s", id, key)
+}
+
+// writeAttributeIndex replaces the attribute index items of the record id, from the traits in old to the traits in new.
+// Either side may be nil for records that are created or deleted.
+func (b *DynamoDBBackend) writeAttributeIndex(id string, old, new map[string]*dynamodb.AttributeValue) error {
+	newKeys := map[string]bool{}
+	for _, key := range attributeKeys(new) {
+		newKeys[key] = true
+	}
+
+	requests := []*dynamodb.WriteRequest{}
+	for _, key := range attributeKeys(old) {
+		if newKeys[key] {
+			continue
+		}
+		requests = append(requests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(attributeIndexID(id, key))}},
+			},
+		})
+	}
+	for key := range newKeys {
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{
+				Item: map[string]*dynamodb.AttributeValue{
+					"ID":       {S: aws.String(attributeIndexID(id, key))},
+					"AttrKey":  {S: aws.String(key)},
+					"RecordID": {S: aws.String(id)},
+				},
+			},
+		})
+	}
+
+	return b.batchWrite(requests)
+}
+
+// FindByImageCID returns the records whose Image points to the given CID.
+func (b *DynamoDBBackend) FindByImageCID(cid string) ([]any, error) {
+	return b.queryIndex(ImageCIDIndex, "ImageCID", cid)
+}
+
+// FindByName returns the records with the given Name, ignoring case and surrounding whitespace.
+func (b *DynamoDBBackend) FindByName(name string) ([]any, error) {
+	return b.queryIndex(NameKeyIndex, "NameKey", NormalizeName(name))
+}
+
+// FindByAttribute returns the records with the given trait, ignoring case and surrounding whitespace.
+func (b *DynamoDBBackend) FindByAttribute(trait string, value any) ([]any, error) {
+	entries, err := b.queryIndex(AttrKeyIndex, "AttrKey", AttributeKey(trait, value))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		m, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		if id, ok := m["RecordID"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return b.ReadBatch(ids)
+}
+
+// queryIndex returns every item of the given global secondary index whose key attribute equals value.
+func (b *DynamoDBBackend) queryIndex(index string, attribute string, value string) ([]any, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key(attribute).Equal(expression.Value(value))).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	items := []any{}
+	var unmarshalErr error
+	err = b.db.QueryPages(&dynamodb.QueryInput{
+		TableName:                 aws.String(b.tableName),
+		IndexName:                 aws.String(index),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var records []any
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &records)
+		if unmarshalErr != nil {
+			return false
+		}
+		items = append(items, records...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return items, nil
+}
@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned when the requested item does not exist.
+var ErrNotFound = errors.New("item not found")
+
+// ErrAlreadyExists is returned when creating an item whose ID is already taken.
+var ErrAlreadyExists = errors.New("item already exists")
+
+// ErrVersionConflict is returned when an update is based on an outdated version of the item.
+var ErrVersionConflict = errors.New("item version conflict")
+
+// ErrHistoryUnsupported is returned when asking for versions from a backend that doesn't keep history.
+var ErrHistoryUnsupported = errors.New("backend does not keep history")
+
+// ErrLookupUnsupported is returned when querying secondary indexes of a backend that doesn't maintain them.
+var ErrLookupUnsupported = errors.New("backend does not support lookups")
+
+// BatchConflictError is returned by CreateBatch when some of the items already existed. Those items were left
+// untouched, all others were written. It matches ErrAlreadyExists.
+type BatchConflictError struct {
+	IDs []string
+}
+
+func (e *BatchConflictError) Error() string {
+	return fmt.Sprintf("%d items already exist", len(e.IDs))
+}
+
+func (e *BatchConflictError) Is(target error) bool {
+	return target == ErrAlreadyExists
+}
+
+// conflictError returns a BatchConflictError for the IDs, or nil if there are none.
+func conflictError(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return &BatchConflictError{IDs: ids}
+}
+
+// BatchFailedError is returned by CreateBatch when some of the items could not be written. Those items were
+// not created, all others were, except for the ones listed in Conflicts, which already existed and were left
+// untouched like in a BatchConflictError. Err is the first error.
+type BatchFailedError struct {
+	IDs       []string
+	Conflicts []string
+	Err       error
+}
+
+func (e *BatchFailedError) Error() string {
+	return fmt.Sprintf("%d items not created: %s", len(e.IDs), e.Err)
+}
+
+func (e *BatchFailedError) Unwrap() error {
+	return e.Err
+}
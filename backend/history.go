@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// History is implemented by backends that keep the prior versions of updated items.
+type History interface {
+	// ListVersions returns every kept version of the item with the given id, oldest first.
+	ListVersions(id string) ([]any, error)
+	// GetVersion returns the given version of the item with the given id, or ErrNotFound.
+	GetVersion(id string, version int64) (any, error)
+}
+
+// HistoryIDPrefix returns the prefix of the history item IDs of the item with the given id.
+func HistoryIDPrefix(id string) string {
+	return fmt.Sprintf("h-%s-", id)
+}
+
+// HistoryID returns the ID of the history item that keeps the given version of the item with the given id.
+// The version is zero padded so history items sort by version.
+func HistoryID(id string, version int64) string {
+	return fmt.Sprintf("%s%010d", HistoryIDPrefix(id), version)
+}
+
+// Change is a single field that differs between two versions of an item.
+type Change struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// Diff returns the top level fields that differ between two versions of an item, sorted by field name.
+// The ID field is ignored since history items carry their own ID.
+func Diff(old, new any) ([]Change, error) {
+	oldFields, err := fields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := fields(new)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range oldFields {
+		names[name] = true
+	}
+	for name := range newFields {
+		names[name] = true
+	}
+	delete(names, "ID")
+	delete(names, "RecordID")
+
+	changes := []Change{}
+	for name := range names {
+		if !reflect.DeepEqual(oldFields[name], newFields[name]) {
+			changes = append(changes, Change{Field: name, Old: oldFields[name], New: newFields[name]})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes, nil
+}
+
+// fetchFields change whenever an item is fetched again, even if its content stays the same.
+// ID, RecordID and the derived index attributes are left out as well.
+var fetchFields = []string{"ID", "RecordID", versionAttribute, "FetchedAt", "Gateway", "ImageCID", "NameKey"}
+
+// sameContent reports whether two versions of an item only differ in their fetchFields.
+func sameContent(old, new any) (bool, error) {
+	oldFields, err := fields(old)
+	if err != nil {
+		return false, err
+	}
+	newFields, err := fields(new)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range fetchFields {
+		delete(oldFields, name)
+		delete(newFields, name)
+	}
+	return reflect.DeepEqual(oldFields, newFields), nil
+}
+
+// fields normalizes a record or typed item into a map of its top level fields.
+func fields(item any) (map[string]any, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := map[string]any{
+		"ID":      "h-d-cid-0000000001",
+		"Name":    "old name",
+		"Image":   "ipfs://image",
+		"Version": float64(1),
+	}
+	new := map[string]any{
+		"ID":          "d-cid",
+		"Name":        "new name",
+		"Image":       "ipfs://image",
+		"Description": "added",
+		"Version":     float64(2),
+	}
+
+	changes, err := Diff(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Change{
+		{Field: "Description", New: "added"},
+		{Field: "Name", Old: "old name", New: "new name"},
+		{Field: "Version", Old: float64(1), New: float64(2)},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	current := map[string]any{"ID": "d-cid", "Name": "name", "FetchedAt": 1, "Gateway": "a", "Version": 1}
+
+	refetched := map[string]any{"ID": "d-cid", "Name": "name", "FetchedAt": 2, "Gateway": "b", "Version": 2}
+	same, err := sameContent(current, refetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same {
+		t.Error("expected a refetch to have the same content")
+	}
+
+	renamed := map[string]any{"ID": "d-cid", "Name": "new name", "FetchedAt": 1, "Gateway": "a", "Version": 1}
+	same, err = sameContent(current, renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same {
+		t.Error("expected a rename to change the content")
+	}
+}
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lookup is implemented by backends that maintain secondary indexes over Metadata records.
+type Lookup interface {
+	// FindByImageCID returns the records whose Image points to the given CID.
+	FindByImageCID(cid string) ([]any, error)
+	// FindByName returns the records with the given Name, ignoring case and surrounding whitespace.
+	FindByName(name string) ([]any, error)
+	// FindByAttribute returns the records with the given trait, ignoring case and surrounding whitespace.
+	FindByAttribute(trait string, value any) ([]any, error)
+}
+
+// NormalizeName returns the form of a name that is indexed.
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// AttributeKey returns the indexed form of a trait, e.g. "background=gold".
+func AttributeKey(trait string, value any) string {
+	return fmt.Sprintf("%s=%s", NormalizeName(trait), NormalizeName(fmt.Sprint(value)))
+}
+
+// recordAttributeKeys returns the AttributeKey of every trait of a record normalized by fields.
+func recordAttributeKeys(m map[string]any) []string {
+	attributes, _ := m["Attributes"].([]any)
+
+	keys := []string{}
+	for _, attributeAny := range attributes {
+		attribute, ok := attributeAny.(map[string]any)
+		if !ok {
+			continue
+		}
+		trait, ok := attribute["trait_type"].(string)
+		if !ok || attribute["value"] == nil {
+			continue
+		}
+		keys = append(keys, AttributeKey(trait, attribute["value"]))
+	}
+
+	return keys
+}
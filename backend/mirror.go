@@ -0,0 +1,506 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mirrorRetryAttempts is how many times a failed secondary write is retried before it is dropped.
+const mirrorRetryAttempts = 5
+
+// mirrorRetryBackoff is the delay before the first retry of a failed secondary write, it doubles per attempt.
+const mirrorRetryBackoff = time.Second
+
+// Secondary is a store a MirrorBackend copies every write to.
+type Secondary struct {
+	Backend Backend
+	// Required makes a failed write to this secondary fail the whole write. A failed create is undone in the
+	// primary, so creating the item again writes it everywhere. Updates and deletes can't be undone, they
+	// fail and are retried in the background. Writes to secondaries that are not required are best-effort
+	// and only retried in the background.
+	Required bool
+}
+
+// MirrorBackend writes to a primary Backend and copies every write to its secondaries.
+// Reads are always served by the primary.
+type MirrorBackend struct {
+	primary     Backend
+	secondaries []Secondary
+	logger      *logrus.Entry
+
+	// pending counts the writes waiting to be retried, retryCh has room for all of them
+	pending  atomic.Int32
+	retryCh  chan mirrorWrite
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	doneCh   chan struct{}
+
+	// queued tracks the IDs with writes waiting to be retried
+	queuedMu sync.Mutex
+	queued   map[string]*queuedID
+}
+
+// queuedID counts the writes of an ID waiting to be retried. Every new write of the ID starts a new
+// generation, queued writes of an older generation are dropped instead of overwriting newer data.
+type queuedID struct {
+	generation uint64
+	count      int
+}
+
+// mirrorWrite is a write to copy to the secondaries, or a failed secondary write waiting to be retried.
+type mirrorWrite struct {
+	id         string
+	item       any
+	deleted    bool
+	generation uint64
+
+	secondary Backend
+	attempt   int
+	next      time.Time
+}
+
+// putWrite returns the write that puts item.
+func putWrite(item any) (mirrorWrite, error) {
+	m, err := fields(item)
+	if err != nil {
+		return mirrorWrite{}, err
+	}
+	id, _ := m["ID"].(string)
+	return mirrorWrite{id: id, item: item}, nil
+}
+
+// apply writes w to a secondary.
+func (w mirrorWrite) apply(secondary Backend) error {
+	if w.deleted {
+		return mirrorDelete(secondary, w.id)
+	}
+	return mirrorPut(secondary, w.item)
+}
+
+// NewMirrorBackend creates a new MirrorBackend. Up to retryBuffer failed best-effort writes are kept
+// for retrying, beyond that they are dropped and logged.
+func NewMirrorBackend(primary Backend, retryBuffer int, secondaries ...Secondary) *MirrorBackend {
+	b := &MirrorBackend{
+		primary:     primary,
+		secondaries: secondaries,
+		logger:      logrus.WithField("component", "MirrorBackend"),
+		retryCh:     make(chan mirrorWrite, retryBuffer),
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+		queued:      map[string]*queuedID{},
+	}
+
+	go b.retry()
+
+	return b
+}
+
+// Close stops retrying failed secondary writes without waiting for their backoff.
+// Writes still waiting to be retried, or failing after Close, are dropped.
+func (b *MirrorBackend) Close() {
+	b.stopOnce.Do(func() { close(b.stopCh) })
+	<-b.doneCh
+}
+
+// Pending returns the number of failed secondary writes waiting to be retried.
+func (b *MirrorBackend) Pending() int {
+	return int(b.pending.Load())
+}
+
+func (b *MirrorBackend) Create(item any) error {
+	w, err := putWrite(item)
+	if err != nil {
+		return err
+	}
+
+	err = b.primary.Create(item)
+	if err != nil {
+		return err
+	}
+
+	return b.mirrorCreate(w)
+}
+
+// CreateBatch only mirrors the items the primary created, items it reported as existing are left alone.
+// Items a required secondary failed on are undone in the primary and reported in a BatchFailedError.
+func (b *MirrorBackend) CreateBatch(items []any) error {
+	writes := make([]mirrorWrite, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		w, err := putWrite(item)
+		if err != nil {
+			return err
+		}
+		// the primary writes a repeated ID once
+		if seen[w.id] {
+			continue
+		}
+		seen[w.id] = true
+		writes = append(writes, w)
+	}
+
+	created := b.primary.CreateBatch(items)
+	var conflict *BatchConflictError
+	if created != nil && !errors.As(created, &conflict) {
+		return created
+	}
+	taken := map[string]bool{}
+	if conflict != nil {
+		for _, id := range conflict.IDs {
+			taken[id] = true
+		}
+	}
+
+	failed := []string{}
+	var first error
+	for _, w := range writes {
+		if taken[w.id] {
+			continue
+		}
+
+		err := b.mirrorCreate(w)
+		if err != nil {
+			failed = append(failed, w.id)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		batchErr := &BatchFailedError{IDs: failed, Err: first}
+		if conflict != nil {
+			batchErr.Conflicts = conflict.IDs
+		}
+		return batchErr
+	}
+	return created
+}
+
+func (b *MirrorBackend) Update(item any) error {
+	w, err := putWrite(item)
+	if err != nil {
+		return err
+	}
+
+	err = b.primary.Update(item)
+	if err != nil {
+		return err
+	}
+
+	return b.mirrorRetrying(&w)
+}
+
+func (b *MirrorBackend) Delete(id string) error {
+	err := b.primary.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	return b.mirrorRetrying(&mirrorWrite{id: id, deleted: true})
+}
+
+func (b *MirrorBackend) Read(id string) (any, error) {
+	return b.primary.Read(id)
+}
+
+func (b *MirrorBackend) ReadBatch(ids []string) ([]any, error) {
+	return b.primary.ReadBatch(ids)
+}
+
+func (b *MirrorBackend) Scan(prefix string) ([]any, error) {
+	return b.primary.Scan(prefix)
+}
+
+func (b *MirrorBackend) ScanPage(prefix string, cursor string, limit int) ([]any, string, error) {
+	return b.primary.ScanPage(prefix, cursor, limit)
+}
+
+func (b *MirrorBackend) ListVersions(id string) ([]any, error) {
+	history, ok := b.primary.(History)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return history.ListVersions(id)
+}
+
+func (b *MirrorBackend) GetVersion(id string, version int64) (any, error) {
+	history, ok := b.primary.(History)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return history.GetVersion(id, version)
+}
+
+func (b *MirrorBackend) FindByImageCID(cid string) ([]any, error) {
+	lookup, ok := b.primary.(Lookup)
+	if !ok {
+		return nil, ErrLookupUnsupported
+	}
+	return lookup.FindByImageCID(cid)
+}
+
+func (b *MirrorBackend) FindByName(name string) ([]any, error) {
+	lookup, ok := b.primary.(Lookup)
+	if !ok {
+		return nil, ErrLookupUnsupported
+	}
+	return lookup.FindByName(name)
+}
+
+func (b *MirrorBackend) FindByAttribute(trait string, value any) ([]any, error) {
+	lookup, ok := b.primary.(Lookup)
+	if !ok {
+		return nil, ErrLookupUnsupported
+	}
+	return lookup.FindByAttribute(trait, value)
+}
+
+// mirror applies w to every secondary and returns the required secondaries it failed on, together with
+// their errors. Failed writes to best-effort secondaries are queued for retrying.
+func (b *MirrorBackend) mirror(w *mirrorWrite) ([]Backend, error) {
+	w.generation = b.supersede(w.id)
+
+	failed := []Backend{}
+	errs := []error{}
+	for _, secondary := range b.secondaries {
+		err := w.apply(secondary.Backend)
+		if err == nil {
+			continue
+		}
+
+		if secondary.Required {
+			failed = append(failed, secondary.Backend)
+			errs = append(errs, fmt.Errorf("required secondary write failed: %w", err))
+			continue
+		}
+
+		b.logger.WithError(err).Warn("Secondary write failed, retrying in the background")
+		b.retryLater(*w, secondary.Backend)
+	}
+
+	return failed, joinErrors(errs)
+}
+
+// mirrorRetrying mirrors a write that can't be undone in the primary. Failed writes to required secondaries
+// are retried in the background too, and their errors are returned.
+func (b *MirrorBackend) mirrorRetrying(w *mirrorWrite) error {
+	failed, err := b.mirror(w)
+	if err != nil {
+		b.retryLater(*w, failed...)
+	}
+	return err
+}
+
+// mirrorCreate mirrors a create. If a required secondary fails, the item is deleted from the primary and the
+// secondaries again, so it isn't skipped as existing when the create is retried.
+func (b *MirrorBackend) mirrorCreate(w mirrorWrite) error {
+	failed, err := b.mirror(&w)
+	if err == nil {
+		return nil
+	}
+
+	undo := b.primary.Delete(w.id)
+	if undo != nil && !errors.Is(undo, ErrNotFound) {
+		b.logger.WithError(undo).WithField("ID", w.id).Error("Failed to undo create in primary, retrying secondary writes in the background")
+		b.retryLater(w, failed...)
+		return err
+	}
+
+	undo = b.mirrorRetrying(&mirrorWrite{id: w.id, deleted: true})
+	if undo != nil {
+		b.logger.WithError(undo).WithField("ID", w.id).Warn("Failed to undo create in required secondary")
+	}
+	return err
+}
+
+// supersede starts a new generation of writes of the ID, so its queued writes are dropped.
+// It returns the generation, which is zero for IDs without queued writes.
+func (b *MirrorBackend) supersede(id string) uint64 {
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+
+	q, ok := b.queued[id]
+	if !ok {
+		return 0
+	}
+	q.generation++
+	return q.generation
+}
+
+// superseded reports whether a newer write of the ID was made after w.
+func (b *MirrorBackend) superseded(w mirrorWrite) bool {
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+
+	q, ok := b.queued[w.id]
+	return ok && w.generation < q.generation
+}
+
+// retryLater queues w for retrying on each of the secondaries.
+func (b *MirrorBackend) retryLater(w mirrorWrite, secondaries ...Backend) {
+	for _, secondary := range secondaries {
+		w.secondary = secondary
+		w.attempt = 1
+		w.next = time.Now().Add(mirrorRetryBackoff)
+		b.enqueue(w)
+	}
+}
+
+// enqueue queues a failed write for retrying, dropping it if the retry buffer is full.
+func (b *MirrorBackend) enqueue(w mirrorWrite) {
+	if int(b.pending.Add(1)) > cap(b.retryCh) {
+		b.pending.Add(-1)
+		b.logger.WithField("attempt", w.attempt).Error("Retry buffer full, dropping secondary write")
+		return
+	}
+
+	b.queuedMu.Lock()
+	q, ok := b.queued[w.id]
+	if !ok {
+		q = &queuedID{generation: w.generation}
+		b.queued[w.id] = q
+	}
+	q.count++
+	b.queuedMu.Unlock()
+
+	// retryCh has room for every pending write, so this doesn't block even after Close
+	b.retryCh <- w
+}
+
+// finish removes a write from the pending writes once it succeeded or was dropped.
+func (b *MirrorBackend) finish(w mirrorWrite) {
+	b.pending.Add(-1)
+
+	b.queuedMu.Lock()
+	defer b.queuedMu.Unlock()
+
+	q, ok := b.queued[w.id]
+	if !ok {
+		return
+	}
+	q.count--
+	if q.count <= 0 {
+		delete(b.queued, w.id)
+	}
+}
+
+// retry retries failed secondary writes until Close is called. Writes are retried in the order they
+// are due, so one write backing off doesn't hold up the others.
+func (b *MirrorBackend) retry() {
+	defer close(b.doneCh)
+
+	// waiting is sorted by next
+	waiting := []mirrorWrite{}
+	for {
+		var timer *time.Timer
+		var due <-chan time.Time
+		if len(waiting) > 0 {
+			timer = time.NewTimer(time.Until(waiting[0].next))
+			due = timer.C
+		}
+
+		select {
+		case <-b.stopCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case w := <-b.retryCh:
+			waiting = insertWrite(waiting, w)
+		case <-due:
+			w := waiting[0]
+			waiting = waiting[1:]
+			if b.retryWrite(&w) {
+				waiting = insertWrite(waiting, w)
+			} else {
+				b.finish(w)
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// retryWrite tries a failed write again, it reports whether the write failed and should be retried once more.
+// Writes superseded by a newer write of the same ID are dropped, the secondary got or will get the newer one.
+func (b *MirrorBackend) retryWrite(w *mirrorWrite) bool {
+	if b.superseded(*w) {
+		b.logger.WithField("ID", w.id).Debug("Dropping secondary write superseded by a newer write")
+		return false
+	}
+
+	err := w.apply(w.secondary)
+	if err == nil {
+		return false
+	}
+
+	if w.attempt >= mirrorRetryAttempts {
+		b.logger.WithError(err).Errorf("Dropping secondary write after %d attempts", w.attempt)
+		return false
+	}
+
+	w.next = time.Now().Add(mirrorRetryBackoff << w.attempt)
+	w.attempt++
+	return true
+}
+
+// insertWrite inserts w into waiting, keeping it sorted by next.
+func insertWrite(waiting []mirrorWrite, w mirrorWrite) []mirrorWrite {
+	i := sort.Search(len(waiting), func(i int) bool { return waiting[i].next.After(w.next) })
+	waiting = append(waiting, mirrorWrite{})
+	copy(waiting[i+1:], waiting[i:])
+	waiting[i] = w
+	return waiting
+}
+
+// mirrorPut writes item to a secondary whatever it holds already. The secondary keeps its own versions,
+// so an existing item is updated on top of the secondary's current version.
+func mirrorPut(secondary Backend, item any) error {
+	err := secondary.Create(item)
+	if !errors.Is(err, ErrAlreadyExists) {
+		return err
+	}
+
+	m, err := fields(item)
+	if err != nil {
+		return err
+	}
+	id, _ := m["ID"].(string)
+
+	current, err := secondary.Read(id)
+	if err != nil {
+		return err
+	}
+	m[versionAttribute] = recordVersion(current)
+
+	return secondary.Update(m)
+}
+
+// mirrorDelete deletes from a secondary, an item that is already gone counts as deleted.
+func mirrorDelete(secondary Backend, id string) error {
+	err := secondary.Delete(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// joinErrors returns nil, the only error, or an error counting all of them and wrapping the first.
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return fmt.Errorf("%d secondary writes failed, first: %w", len(errs), errs[0])
+	}
+}
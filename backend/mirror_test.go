@@ -0,0 +1,260 @@
+package backend
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("backend unavailable")
+
+// memoryBackend keeps items in a map and fails the next failures writes.
+type memoryBackend struct {
+	Backend
+	mu       sync.Mutex
+	items    map[string]map[string]any
+	failures int
+}
+
+func newMemoryBackend(failures int) *memoryBackend {
+	return &memoryBackend{items: map[string]map[string]any{}, failures: failures}
+}
+
+// fail reports whether the current write fails, b.mu must be held.
+func (b *memoryBackend) fail() bool {
+	if b.failures == 0 {
+		return false
+	}
+	b.failures--
+	return true
+}
+
+func (b *memoryBackend) Create(item any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail() {
+		return errUnavailable
+	}
+
+	m, err := fields(item)
+	if err != nil {
+		return err
+	}
+	id, _ := m["ID"].(string)
+	if _, ok := b.items[id]; ok {
+		return ErrAlreadyExists
+	}
+	b.items[id] = m
+	return nil
+}
+
+func (b *memoryBackend) CreateBatch(items []any) error {
+	taken := []string{}
+	for _, item := range items {
+		err := b.Create(item)
+		if errors.Is(err, ErrAlreadyExists) {
+			m, _ := fields(item)
+			taken = append(taken, m["ID"].(string))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return conflictError(taken)
+}
+
+func (b *memoryBackend) Read(id string) (any, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item, ok := b.items[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return item, nil
+}
+
+func (b *memoryBackend) Update(item any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail() {
+		return errUnavailable
+	}
+
+	m, err := fields(item)
+	if err != nil {
+		return err
+	}
+	id, _ := m["ID"].(string)
+	b.items[id] = m
+	return nil
+}
+
+func (b *memoryBackend) Delete(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail() {
+		return errUnavailable
+	}
+
+	if _, ok := b.items[id]; !ok {
+		return ErrNotFound
+	}
+	delete(b.items, id)
+	return nil
+}
+
+func (b *memoryBackend) get(id string) map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.items[id]
+}
+
+func (b *memoryBackend) has(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.items[id]
+	return ok
+}
+
+func TestMirrorBackendSecondaries(t *testing.T) {
+	primary := newMemoryBackend(0)
+	required := newMemoryBackend(1)
+	bestEffort := newMemoryBackend(1)
+	b := NewMirrorBackend(primary, 10,
+		Secondary{Backend: required, Required: true},
+		Secondary{Backend: bestEffort},
+	)
+	defer b.Close()
+
+	// a failed required write fails the write, a failed best-effort write is retried in the background
+	err := b.Create(map[string]any{"ID": "d-a"})
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("expected the required secondary's error, got %v", err)
+	}
+	if b.Pending() != 1 {
+		t.Errorf("expected 1 pending retry, got %d", b.Pending())
+	}
+	// the failed create is undone, so creating it again isn't skipped
+	if primary.has("d-a") {
+		t.Error("expected d-a to be removed from the primary")
+	}
+
+	err = b.Create(map[string]any{"ID": "d-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !required.has("d-b") || !bestEffort.has("d-b") {
+		t.Error("expected d-b to be mirrored to both secondaries")
+	}
+
+	// an update can't be undone, so it fails and is retried in the background
+	required.failures = 1
+	err = b.Update(map[string]any{"ID": "d-b", "Name": "new"})
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("expected the required secondary's error, got %v", err)
+	}
+	if b.Pending() != 2 {
+		t.Errorf("expected 2 pending retries, got %d", b.Pending())
+	}
+}
+
+func TestMirrorBackendCreateBatch(t *testing.T) {
+	primary := newMemoryBackend(0)
+	required := newMemoryBackend(1)
+	b := NewMirrorBackend(primary, 10, Secondary{Backend: required, Required: true})
+	defer b.Close()
+
+	err := primary.Create(map[string]any{"ID": "d-taken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.CreateBatch([]any{
+		map[string]any{"ID": "d-a"},
+		map[string]any{"ID": "d-taken"},
+		map[string]any{"ID": "d-b"},
+	})
+	var failed *BatchFailedError
+	if !errors.As(err, &failed) {
+		t.Fatalf("expected a BatchFailedError, got %v", err)
+	}
+	if len(failed.IDs) != 1 || failed.IDs[0] != "d-a" || len(failed.Conflicts) != 1 || failed.Conflicts[0] != "d-taken" {
+		t.Errorf("expected d-a to fail and d-taken to conflict, got %+v", failed)
+	}
+
+	// only the failed item is undone, the others stay created and mirrored
+	if primary.has("d-a") || !primary.has("d-b") || !required.has("d-b") {
+		t.Error("expected only d-b to be created")
+	}
+}
+
+func TestMirrorBackendDropsSupersededRetry(t *testing.T) {
+	secondary := newMemoryBackend(1)
+	b := NewMirrorBackend(newMemoryBackend(0), 10, Secondary{Backend: secondary})
+	defer b.Close()
+
+	err := b.Create(map[string]any{"ID": "d-a", "Name": "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Update(map[string]any{"ID": "d-a", "Name": "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the queued create is older than the update the secondary took, so it's dropped
+	deadline := time.Now().Add(3 * mirrorRetryBackoff)
+	for b.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Pending() != 0 {
+		t.Fatalf("expected the retry to be dropped, %d writes pending", b.Pending())
+	}
+	if name := secondary.get("d-a")["Name"]; name != "new" {
+		t.Errorf("expected the newer write to be kept, got %v", name)
+	}
+}
+
+func TestMirrorBackendRetry(t *testing.T) {
+	secondary := newMemoryBackend(1)
+	b := NewMirrorBackend(newMemoryBackend(0), 10, Secondary{Backend: secondary})
+	defer b.Close()
+
+	err := b.Create(map[string]any{"ID": "d-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * mirrorRetryBackoff)
+	for b.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Pending() != 0 || !secondary.has("d-a") {
+		t.Errorf("expected d-a to be retried, %d writes pending", b.Pending())
+	}
+}
+
+func TestMirrorBackendClose(t *testing.T) {
+	b := NewMirrorBackend(newMemoryBackend(0), 1, Secondary{Backend: newMemoryBackend(10)})
+
+	err := b.Create(map[string]any{"ID": "d-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Close doesn't wait for the backoff of pending writes
+	start := time.Now()
+	b.Close()
+	if elapsed := time.Since(start); elapsed >= mirrorRetryBackoff {
+		t.Errorf("expected Close to return right away, took %s", elapsed)
+	}
+
+	// writes failing after Close are dropped
+	err = b.Create(map[string]any{"ID": "d-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+}
@@ -0,0 +1,388 @@
+package backend
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/lib/pq"
+)
+
+// PostgresBackend stores items in the metadata table of a PostgreSQL database, see the postgres package for the schema.
+// Metadata fields are kept as typed columns next to the raw item in a JSONB column.
+type PostgresBackend struct {
+	db *sql.DB
+}
+
+// NewPostgresBackend creates a new PostgresBackend, db must have been migrated with postgres.Migrate.
+func NewPostgresBackend(db *sql.DB) (Backend, error) {
+	err := db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgresBackend{db: db}, nil
+}
+
+// postgresRow is an item split into the columns of the metadata table.
+type postgresRow struct {
+	id          string
+	cid         string
+	name        string
+	description string
+	image       string
+	gateway     string
+	fetchedAt   int64
+	version     int64
+	imageCID    sql.NullString
+	nameKey     sql.NullString
+	data        []byte
+	attributes  []string
+}
+
+// newPostgresRow splits item into columns, with the given version.
+func newPostgresRow(item any, version int64) (postgresRow, error) {
+	m, err := fields(item)
+	if err != nil {
+		return postgresRow{}, err
+	}
+	m[versionAttribute] = version
+
+	id, _ := m["ID"].(string)
+	if id == "" {
+		return postgresRow{}, errors.New("item has no ID")
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return postgresRow{}, err
+	}
+
+	row := postgresRow{
+		id:         id,
+		version:    version,
+		data:       data,
+		attributes: recordAttributeKeys(m),
+	}
+	row.cid, _ = m["CID"].(string)
+	row.name, _ = m["Name"].(string)
+	row.description, _ = m["Description"].(string)
+	row.image, _ = m["Image"].(string)
+	row.gateway, _ = m["Gateway"].(string)
+	if fetchedAt, ok := m["FetchedAt"].(float64); ok {
+		row.fetchedAt = int64(fetchedAt)
+	}
+	if imageCID := ipfs.ImageCID(row.image); imageCID != "" {
+		row.imageCID = sql.NullString{String: imageCID, Valid: true}
+	}
+	if nameKey := NormalizeName(row.name); nameKey != "" {
+		row.nameKey = sql.NullString{String: nameKey, Valid: true}
+	}
+
+	return row, nil
+}
+
+// upsertSQL writes a row, overwriting an existing one when used with DO UPDATE.
+const upsertSQL = `
+INSERT INTO metadata (id, cid, name, description, image, gateway, fetched_at, version, image_cid, name_key, data)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (id) DO `
+
+const upsertUpdateSQL = `UPDATE SET
+	cid = EXCLUDED.cid, name = EXCLUDED.name, description = EXCLUDED.description, image = EXCLUDED.image,
+	gateway = EXCLUDED.gateway, fetched_at = EXCLUDED.fetched_at, version = EXCLUDED.version,
+	image_cid = EXCLUDED.image_cid, name_key = EXCLUDED.name_key, data = EXCLUDED.data`
+
+// write inserts or overwrites row in tx and replaces its attribute index, it reports whether a row was written.
+func (b *PostgresBackend) write(tx *sql.Tx, row postgresRow, overwrite bool) (bool, error) {
+	query := upsertSQL + "NOTHING"
+	if overwrite {
+		query = upsertSQL + upsertUpdateSQL
+	}
+
+	result, err := tx.Exec(query, row.id, row.cid, row.name, row.description, row.image, row.gateway,
+		row.fetchedAt, row.version, row.imageCID, row.nameKey, row.data)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM metadata_attributes WHERE id = $1`, row.id)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range row.attributes {
+		_, err = tx.Exec(`INSERT INTO metadata_attributes (id, attr_key) VALUES ($1, $2) ON CONFLICT DO NOTHING`, row.id, key)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds.
+func (b *PostgresBackend) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Create writes a new item with version 1, it fails with ErrAlreadyExists if the ID is taken.
+func (b *PostgresBackend) Create(item any) error {
+	row, err := newPostgresRow(item, 1)
+	if err != nil {
+		return err
+	}
+
+	return b.inTx(func(tx *sql.Tx) error {
+		written, err := b.write(tx, row, false)
+		if err != nil {
+			return err
+		}
+		if !written {
+			return ErrAlreadyExists
+		}
+		return nil
+	})
+}
+
+// CreateBatch writes new items with version 1 in one transaction. Like Create it never overwrites: items whose
+// ID is taken are left untouched and reported in a BatchConflictError, the others are written.
+func (b *PostgresBackend) CreateBatch(items []any) error {
+	rows := make([]postgresRow, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		row, err := newPostgresRow(item, 1)
+		if err != nil {
+			return err
+		}
+		// a repeated ID is written once by its first occurrence, like in DynamoDBBackend.CreateBatch
+		if seen[row.id] {
+			continue
+		}
+		seen[row.id] = true
+		rows = append(rows, row)
+	}
+
+	conflicts := []string{}
+	err := b.inTx(func(tx *sql.Tx) error {
+		for _, row := range rows {
+			written, err := b.write(tx, row, false)
+			if err != nil {
+				return err
+			}
+			if !written {
+				conflicts = append(conflicts, row.id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return conflictError(conflicts)
+}
+
+func (b *PostgresBackend) Read(id string) (any, error) {
+	var data []byte
+	err := b.db.QueryRow(`SELECT data FROM metadata WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRecord(data)
+}
+
+func (b *PostgresBackend) ReadBatch(ids []string) ([]any, error) {
+	return b.query(`SELECT data FROM metadata WHERE id = ANY($1)`, pq.Array(ids))
+}
+
+// Update replaces an existing item. The item's Version must match the stored one, on success the stored
+// Version is incremented. It fails with ErrNotFound if the item doesn't exist and ErrVersionConflict if
+// someone else wrote it in the meantime. The replaced version is kept in the metadata_history table.
+// Like DynamoDBBackend.Update it keeps the Version of updates that only change when and where the item was fetched.
+func (b *PostgresBackend) Update(item any) error {
+	m, err := fields(item)
+	if err != nil {
+		return err
+	}
+	version := recordVersion(m)
+
+	row, err := newPostgresRow(m, version+1)
+	if err != nil {
+		return err
+	}
+
+	return b.inTx(func(tx *sql.Tx) error {
+		var current []byte
+		var currentVersion int64
+		err := tx.QueryRow(`SELECT data, version FROM metadata WHERE id = $1 FOR UPDATE`, row.id).Scan(&current, &currentVersion)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if currentVersion != version {
+			return ErrVersionConflict
+		}
+
+		same, err := sameContent(json.RawMessage(current), m)
+		if err != nil {
+			return err
+		}
+		if same {
+			// only when and where it was fetched changed, that's no new version
+			row, err := newPostgresRow(m, version)
+			if err != nil {
+				return err
+			}
+			_, err = b.write(tx, row, true)
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO metadata_history (id, version, data) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, row.id, currentVersion, current)
+		if err != nil {
+			return err
+		}
+
+		_, err = b.write(tx, row, true)
+		return err
+	})
+}
+
+func (b *PostgresBackend) Delete(id string) error {
+	_, err := b.db.Exec(`DELETE FROM metadata WHERE id = $1`, id)
+	return err
+}
+
+// Scan returns every item whose ID starts with prefix. Prefer ScanPage for large keyspaces.
+func (b *PostgresBackend) Scan(prefix string) ([]any, error) {
+	return b.query(`SELECT data FROM metadata WHERE id LIKE $1 ORDER BY id`, likePrefix(prefix))
+}
+
+// ScanPage returns up to limit items whose ID starts with prefix, in ID order, starting after cursor.
+// The returned cursor resumes the scan and is empty once the keyspace is exhausted.
+func (b *PostgresBackend) ScanPage(prefix string, cursor string, limit int) ([]any, string, error) {
+	after, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	items, err := b.query(`SELECT data FROM metadata WHERE id LIKE $1 AND id > $2 ORDER BY id LIMIT $3`,
+		likePrefix(prefix), string(after), limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(items) == limit {
+		next = base64.RawURLEncoding.EncodeToString([]byte(recordID(items[len(items)-1])))
+	}
+
+	return items, next, nil
+}
+
+// ListVersions returns every kept version of the item with the given id, oldest first.
+// The last entry is the current item.
+func (b *PostgresBackend) ListVersions(id string) ([]any, error) {
+	return b.query(`
+		SELECT data FROM (
+			SELECT data, version FROM metadata_history WHERE id = $1
+			UNION ALL
+			SELECT data, version FROM metadata WHERE id = $1
+		) versions ORDER BY version`, id)
+}
+
+// GetVersion returns the given version of the item with the given id, or ErrNotFound.
+func (b *PostgresBackend) GetVersion(id string, version int64) (any, error) {
+	items, err := b.query(`
+		SELECT data FROM metadata_history WHERE id = $1 AND version = $2
+		UNION ALL
+		SELECT data FROM metadata WHERE id = $1 AND version = $2`, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return items[0], nil
+}
+
+// FindByImageCID returns the records whose Image points to the given CID.
+func (b *PostgresBackend) FindByImageCID(cid string) ([]any, error) {
+	return b.query(`SELECT data FROM metadata WHERE image_cid = $1 ORDER BY id`, cid)
+}
+
+// FindByName returns the records with the given Name, ignoring case and surrounding whitespace.
+func (b *PostgresBackend) FindByName(name string) ([]any, error) {
+	return b.query(`SELECT data FROM metadata WHERE name_key = $1 ORDER BY id`, NormalizeName(name))
+}
+
+// FindByAttribute returns the records with the given trait, ignoring case and surrounding whitespace.
+func (b *PostgresBackend) FindByAttribute(trait string, value any) ([]any, error) {
+	return b.query(`
+		SELECT m.data FROM metadata m
+		JOIN metadata_attributes a ON a.id = m.id
+		WHERE a.attr_key = $1 ORDER BY m.id`, AttributeKey(trait, value))
+}
+
+// query returns the decoded data column of every row the query returns.
+func (b *PostgresBackend) query(query string, args ...any) ([]any, error) {
+	rows, err := b.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []any{}
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		item, err := decodeRecord(data)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// decodeRecord decodes a JSONB item into the same shape DynamoDBBackend returns.
+func decodeRecord(data []byte) (any, error) {
+	var m map[string]any
+	err := json.Unmarshal(data, &m)
+	return m, err
+}
+
+// likePrefix turns prefix into a LIKE pattern matching everything that starts with it.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+}
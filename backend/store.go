@@ -0,0 +1,201 @@
+package backend
+
+import "encoding/json"
+
+// Store is a typed layer over a Backend so callers don't have to re-decode the generic records it returns.
+type Store[T any] struct {
+	backend Backend
+}
+
+// NewStore creates a new Store of T over the given Backend.
+func NewStore[T any](b Backend) *Store[T] {
+	return &Store[T]{backend: b}
+}
+
+// Backend returns the underlying Backend.
+func (s *Store[T]) Backend() Backend {
+	return s.backend
+}
+
+// Get returns the item with the given id, or ErrNotFound.
+func (s *Store[T]) Get(id string) (T, error) {
+	record, err := s.backend.Read(id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return decode[T](record)
+}
+
+// GetBatch returns the items that exist for the given ids, missing ids are left out.
+func (s *Store[T]) GetBatch(ids []string) ([]T, error) {
+	records, err := s.backend.ReadBatch(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAll[T](records)
+}
+
+// Put writes a new item, it fails with ErrAlreadyExists if the ID is taken.
+func (s *Store[T]) Put(item T) error {
+	return s.backend.Create(item)
+}
+
+// Update replaces an existing item, see Backend.Update for the version check.
+func (s *Store[T]) Update(item T) error {
+	return s.backend.Update(item)
+}
+
+// PutBatch writes many new items at once, existing items are skipped and reported in a BatchConflictError.
+func (s *Store[T]) PutBatch(items []T) error {
+	batch := make([]any, 0, len(items))
+	for _, item := range items {
+		batch = append(batch, item)
+	}
+
+	return s.backend.CreateBatch(batch)
+}
+
+// List returns every item whose ID starts with prefix.
+func (s *Store[T]) List(prefix string) ([]T, error) {
+	records, err := s.backend.Scan(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAll[T](records)
+}
+
+// ListPage returns up to limit items whose ID starts with prefix, starting after cursor.
+// The returned cursor resumes the listing and is empty once there are no more items.
+func (s *Store[T]) ListPage(prefix string, cursor string, limit int) ([]T, string, error) {
+	records, next, err := s.backend.ScanPage(prefix, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	items, err := decodeAll[T](records)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return items, next, nil
+}
+
+// Each calls fn for every item whose ID starts with prefix, holding at most pageSize items in memory.
+// Iteration stops at the first error returned by fn.
+func (s *Store[T]) Each(prefix string, pageSize int, fn func(T) error) error {
+	cursor := ""
+	for {
+		items, next, err := s.ListPage(prefix, cursor, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			err = fn(item)
+			if err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Versions returns every kept version of the item with the given id, oldest first.
+func (s *Store[T]) Versions(id string) ([]T, error) {
+	history, ok := s.backend.(History)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+
+	records, err := history.ListVersions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAll[T](records)
+}
+
+// Version returns the given version of the item with the given id.
+func (s *Store[T]) Version(id string, version int64) (T, error) {
+	var zero T
+
+	history, ok := s.backend.(History)
+	if !ok {
+		return zero, ErrHistoryUnsupported
+	}
+
+	record, err := history.GetVersion(id, version)
+	if err != nil {
+		return zero, err
+	}
+
+	return decode[T](record)
+}
+
+// FindByImageCID returns the items whose Image points to the given CID.
+func (s *Store[T]) FindByImageCID(cid string) ([]T, error) {
+	return s.find(func(l Lookup) ([]any, error) { return l.FindByImageCID(cid) })
+}
+
+// FindByName returns the items with the given Name, ignoring case and surrounding whitespace.
+func (s *Store[T]) FindByName(name string) ([]T, error) {
+	return s.find(func(l Lookup) ([]any, error) { return l.FindByName(name) })
+}
+
+// FindByAttribute returns the items with the given trait, ignoring case and surrounding whitespace.
+func (s *Store[T]) FindByAttribute(trait string, value any) ([]T, error) {
+	return s.find(func(l Lookup) ([]any, error) { return l.FindByAttribute(trait, value) })
+}
+
+func (s *Store[T]) find(query func(Lookup) ([]any, error)) ([]T, error) {
+	lookup, ok := s.backend.(Lookup)
+	if !ok {
+		return nil, ErrLookupUnsupported
+	}
+
+	records, err := query(lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAll[T](records)
+}
+
+// Delete removes the item with the given id.
+func (s *Store[T]) Delete(id string) error {
+	return s.backend.Delete(id)
+}
+
+// decode converts a generic record as returned by a Backend into T.
+func decode[T any](record any) (T, error) {
+	var item T
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return item, err
+	}
+
+	err = json.Unmarshal(b, &item)
+	return item, err
+}
+
+func decodeAll[T any](records []any) ([]T, error) {
+	items := make([]T, 0, len(records))
+	for _, record := range records {
+		item, err := decode[T](record)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
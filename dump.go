@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/dump"
+	"github.com/sirupsen/logrus"
+)
+
+// runDump runs the export and import commands against the given backend.
+func runDump(command string, args []string, b backend.Backend) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	format := flags.String("format", string(dump.JSONL), "dump format, jsonl or parquet")
+	path := flags.String("file", "", "dump file to write to or read from")
+	prefix := flags.String("prefix", "", "ID prefix of the records to export, defaults to all metadata records")
+	cursor := flags.String("cursor", "", "cursor to resume an interrupted export from")
+	batchSize := flags.Int("batch-size", 100, "records read or written per backend call")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *path == "" {
+		return fmt.Errorf("-file is required")
+	}
+
+	switch command {
+	case "export":
+		return runExport(dump.Format(*format), *path, b, dump.ExportOptions{
+			Prefix:   *prefix,
+			Cursor:   *cursor,
+			PageSize: *batchSize,
+			OnPage: func(cursor string) {
+				if cursor != "" {
+					logrus.Infof("Exported page, resume with -cursor %s", cursor)
+				}
+			},
+		})
+	case "import":
+		return runImport(dump.Format(*format), *path, b, *batchSize)
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+}
+
+func runExport(format dump.Format, path string, b backend.Backend, opts dump.ExportOptions) error {
+	// a resumed JSON Lines export appends to the earlier one, Parquet files can't be appended to,
+	// so a resumed Parquet export goes to a new part file and never truncates the earlier one
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if opts.Cursor != "" {
+		switch format {
+		case dump.JSONL:
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		case dump.Parquet:
+			flags = os.O_CREATE | os.O_WRONLY | os.O_EXCL
+		}
+	}
+
+	file, err := os.OpenFile(path, flags, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s exists, a resumed Parquet export has to be written to a new -file", path)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var w dump.Writer
+	switch format {
+	case dump.JSONL:
+		w = dump.NewJSONLWriter(file)
+	case dump.Parquet:
+		w, err = dump.NewParquetWriter(file)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+
+	count, err := dump.Export(b, w, opts)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Exported %d records to %s", count, path)
+	return file.Close()
+}
+
+func runImport(format dump.Format, path string, b backend.Backend, batchSize int) error {
+	var r dump.Reader
+	switch format {
+	case dump.JSONL:
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = dump.NewJSONLReader(file)
+	case dump.Parquet:
+		var err error
+		r, err = dump.NewParquetReader(path)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	defer r.Close()
+
+	count, err := dump.Import(b, r, batchSize)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Imported %d records from %s", count, path)
+	return nil
+}
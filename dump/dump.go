@@ -0,0 +1,92 @@
+/*
+Package dump exports Metadata records from any backend.Backend to JSON Lines or Parquet files,
+and imports such dumps back into any backend.Backend.
+*/
+package dump
+
+import (
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/sirupsen/logrus"
+)
+
+// Format is a dump file format.
+type Format string
+
+const (
+	// JSONL is JSON Lines, one Metadata object per line.
+	JSONL Format = "jsonl"
+	// Parquet is an Apache Parquet file with one row per Metadata.
+	Parquet Format = "parquet"
+)
+
+// defaultPageSize is how many records are read from or written to the backend at once.
+const defaultPageSize = 100
+
+// Writer writes Metadata to a dump.
+type Writer interface {
+	Write(metadata ipfs.Metadata) error
+	// Close flushes the dump, it does not close the underlying file.
+	Close() error
+}
+
+// Reader reads Metadata from a dump. Read returns io.EOF once the dump is exhausted.
+type Reader interface {
+	Read() (ipfs.Metadata, error)
+	Close() error
+}
+
+// ExportOptions control an Export.
+type ExportOptions struct {
+	// Prefix selects the records to export, defaults to ipfs.MetadataIDPrefix.
+	Prefix string
+	// Cursor resumes an earlier export after the last page it completed.
+	Cursor string
+	// PageSize is how many records are held in memory at once.
+	PageSize int
+	// OnPage is called with the cursor after every page written, an export interrupted
+	// afterwards resumes from that cursor.
+	OnPage func(cursor string)
+}
+
+// Export streams the records selected by opts from b into w and returns the number of records written.
+func Export(b backend.Backend, w Writer, opts ExportOptions) (int, error) {
+	logger := logrus.WithField("component", "Export")
+	store := backend.NewStore[ipfs.Metadata](b)
+
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = ipfs.MetadataIDPrefix
+	}
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	count := 0
+	cursor := opts.Cursor
+	for {
+		items, next, err := store.ListPage(prefix, cursor, pageSize)
+		if err != nil {
+			return count, err
+		}
+
+		for _, item := range items {
+			err = w.Write(item)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if opts.OnPage != nil {
+			opts.OnPage(next)
+		}
+		logger.WithField("cursor", next).Debugf("Exported %d records", count)
+
+		if next == "" {
+			return count, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,61 @@
+package dump
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/sirupsen/logrus"
+)
+
+// Import bulk-loads every record of r into b, batchSize records at a time, and returns the number of records written.
+// Records are written with backend.Backend.CreateBatch, so existing records with the same ID are kept and skipped.
+func Import(b backend.Backend, r Reader, batchSize int) (int, error) {
+	logger := logrus.WithField("component", "Import")
+	store := backend.NewStore[ipfs.Metadata](b)
+
+	if batchSize <= 0 {
+		batchSize = defaultPageSize
+	}
+
+	count := 0
+	batch := make([]ipfs.Metadata, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := store.PutBatch(batch)
+		var conflict *backend.BatchConflictError
+		if errors.As(err, &conflict) {
+			logger.Debugf("Skipped %d existing records", len(conflict.IDs))
+			count -= len(conflict.IDs)
+		} else if err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		logger.Debugf("Imported %d records", count)
+		return nil
+	}
+
+	for {
+		metadata, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		batch = append(batch, metadata)
+		if len(batch) == batchSize {
+			err = flush()
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+
+	return count, flush()
+}
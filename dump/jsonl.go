@@ -0,0 +1,44 @@
+package dump
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/ipfs-scrape/worker/ipfs"
+)
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+// NewJSONLWriter creates a Writer that writes one JSON object per line to w.
+func NewJSONLWriter(w io.Writer) Writer {
+	return &jsonlWriter{encoder: json.NewEncoder(w)}
+}
+
+func (w *jsonlWriter) Write(metadata ipfs.Metadata) error {
+	return w.encoder.Encode(metadata)
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+type jsonlReader struct {
+	decoder *json.Decoder
+}
+
+// NewJSONLReader creates a Reader for JSON Lines written by a JSONL Writer.
+func NewJSONLReader(r io.Reader) Reader {
+	return &jsonlReader{decoder: json.NewDecoder(r)}
+}
+
+func (r *jsonlReader) Read() (ipfs.Metadata, error) {
+	var metadata ipfs.Metadata
+	err := r.decoder.Decode(&metadata)
+	return metadata, err
+}
+
+func (r *jsonlReader) Close() error {
+	return nil
+}
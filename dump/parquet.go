@@ -0,0 +1,132 @@
+package dump
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRow is the Parquet schema of a Metadata. Attributes are stored as a JSON array.
+type parquetRow struct {
+	ID          string `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CID         string `parquet:"name=cid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Image       string `parquet:"name=image, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Description string `parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name        string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Attributes  string `parquet:"name=attributes, type=BYTE_ARRAY, convertedtype=UTF8"`
+	FetchedAt   int64  `parquet:"name=fetched_at, type=INT64"`
+	Gateway     string `parquet:"name=gateway, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Version     int64  `parquet:"name=version, type=INT64"`
+	Error       string `parquet:"name=error, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// parquetParallelism is the number of goroutines the Parquet library uses for encoding and decoding.
+const parquetParallelism = 4
+
+type parquetWriter struct {
+	writer *writer.ParquetWriter
+}
+
+// NewParquetWriter creates a Writer that writes a Parquet file to w.
+func NewParquetWriter(w io.Writer) (Writer, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), parquetParallelism)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parquetWriter{writer: pw}, nil
+}
+
+func (w *parquetWriter) Write(metadata ipfs.Metadata) error {
+	attributes, err := json.Marshal(metadata.Attributes)
+	if err != nil {
+		return err
+	}
+
+	return w.writer.Write(parquetRow{
+		ID:          metadata.ID,
+		CID:         metadata.CID,
+		Image:       metadata.Image,
+		Description: metadata.Description,
+		Name:        metadata.Name,
+		Attributes:  string(attributes),
+		FetchedAt:   metadata.FetchedAt,
+		Gateway:     metadata.Gateway,
+		Version:     metadata.Version,
+		Error:       metadata.Error,
+	})
+}
+
+func (w *parquetWriter) Close() error {
+	return w.writer.WriteStop()
+}
+
+type parquetReader struct {
+	file   source.ParquetFile
+	reader *reader.ParquetReader
+	rows   []parquetRow
+	left   int64
+}
+
+// NewParquetReader creates a Reader for the Parquet file at path written by a Parquet Writer.
+// Unlike the other readers it closes the file on Close.
+func NewParquetReader(path string) (Reader, error) {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := reader.NewParquetReader(file, new(parquetRow), parquetParallelism)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &parquetReader{file: file, reader: pr, left: pr.GetNumRows()}, nil
+}
+
+func (r *parquetReader) Read() (ipfs.Metadata, error) {
+	if len(r.rows) == 0 {
+		if r.left == 0 {
+			return ipfs.Metadata{}, io.EOF
+		}
+
+		n := int64(defaultPageSize)
+		if n > r.left {
+			n = r.left
+		}
+		r.rows = make([]parquetRow, n)
+		err := r.reader.Read(&r.rows)
+		if err != nil {
+			return ipfs.Metadata{}, err
+		}
+		r.left -= n
+	}
+
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+
+	metadata := ipfs.Metadata{
+		ID:          row.ID,
+		CID:         row.CID,
+		Image:       row.Image,
+		Description: row.Description,
+		Name:        row.Name,
+		FetchedAt:   row.FetchedAt,
+		Gateway:     row.Gateway,
+		Version:     row.Version,
+		Error:       row.Error,
+	}
+	err := json.Unmarshal([]byte(row.Attributes), &metadata.Attributes)
+	return metadata, err
+}
+
+func (r *parquetReader) Close() error {
+	r.reader.ReadStop()
+	return r.file.Close()
+}
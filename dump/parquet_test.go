@@ -0,0 +1,70 @@
+package dump
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ipfs-scrape/worker/ipfs"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.parquet")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ipfs.Metadata{}
+	for i := 0; i < 150; i++ {
+		expected = append(expected, ipfs.Metadata{
+			ID:         ipfs.GenerateIDFromCID("cid"),
+			CID:        "cid",
+			Name:       "name",
+			Attributes: []ipfs.Attribute{{TraitType: "Background", Value: "Gold"}},
+			FetchedAt:  int64(i),
+			Version:    1,
+		})
+	}
+
+	w, err := NewParquetWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metadata := range expected {
+		err = w.Write(metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	r, err := NewParquetReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	actual := []ipfs.Metadata{}
+	for {
+		metadata, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, metadata)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %d records, got %d: %v", len(expected), len(actual), actual[:1])
+	}
+}
@@ -1,6 +1,11 @@
 package ipfs
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
 
 type Metadata struct {
 	ID          string `json:"ID"`
@@ -8,9 +13,82 @@ type Metadata struct {
 	Image       string `json:"Image"`
 	Description string `json:"Description"`
 	Name        string `json:"Name"`
+	// Attributes are the token traits, as in the ERC-721 metadata "attributes" array.
+	Attributes Attributes `json:"Attributes,omitempty"`
+	// FetchedAt is the unix time the metadata was last fetched from the gateway.
+	FetchedAt int64 `json:"FetchedAt"`
+	// Gateway is the IPFS gateway the metadata was fetched from.
+	Gateway string `json:"Gateway"`
+	// Version is maintained by the backend and checked on every update.
+	Version int64 `json:"Version"`
+	// Error is only set on negative records of CIDs that permanently failed to fetch, it says why.
+	Error string `json:"Error,omitempty"`
 }
 
+// Attribute is a single trait of a token.
+type Attribute struct {
+	TraitType string `json:"trait_type"`
+	Value     any    `json:"value"`
+}
+
+// Attributes are the traits of a token. Metadata in the wild doesn't always follow the ERC-721 array of
+// {"trait_type", "value"} objects, so decoding is lenient: an object is read as trait types mapped to values,
+// other values as no attributes, and entries of an array that aren't attributes are skipped.
+type Attributes []Attribute
+
+func (a *Attributes) UnmarshalJSON(data []byte) error {
+	*a = nil
+
+	var entries []json.RawMessage
+	if json.Unmarshal(data, &entries) == nil {
+		for _, entry := range entries {
+			var attribute Attribute
+			if json.Unmarshal(entry, &attribute) != nil || (attribute.TraitType == "" && attribute.Value == nil) {
+				continue
+			}
+			*a = append(*a, attribute)
+		}
+		return nil
+	}
+
+	var traits map[string]any
+	if json.Unmarshal(data, &traits) == nil {
+		types := make([]string, 0, len(traits))
+		for traitType := range traits {
+			types = append(types, traitType)
+		}
+		sort.Strings(types)
+		for _, traitType := range types {
+			*a = append(*a, Attribute{TraitType: traitType, Value: traits[traitType]})
+		}
+	}
+	return nil
+}
+
+// MetadataIDPrefix is the prefix of every Metadata record ID in the backend.
+const MetadataIDPrefix = "d-"
+
 func GenerateIDFromCID(cid string) string {
-	return fmt.Sprintf("d-%s", cid)
+	return fmt.Sprintf("%s%s", MetadataIDPrefix, cid)
+
+}
+
+// ImageCID returns the CID an image URI points to, or an empty string if it doesn't point into IPFS.
+// It understands ipfs://<cid>/..., ipfs://ipfs/<cid>/... and gateway URLs like https://host/ipfs/<cid>/...
+func ImageCID(image string) string {
+	var rest string
+	switch {
+	case strings.HasPrefix(image, "ipfs://"):
+		rest = strings.TrimPrefix(strings.TrimPrefix(image, "ipfs://"), "ipfs/")
+	case strings.Contains(image, "/ipfs/"):
+		rest = image[strings.Index(image, "/ipfs/")+len("/ipfs/"):]
+	default:
+		return ""
+	}
+
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest = rest[:i]
+	}
 
+	return rest
 }
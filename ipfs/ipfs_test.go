@@ -0,0 +1,34 @@
+package ipfs
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAttributesUnmarshalJSON(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		json string
+		want Attributes
+	}{
+		{"array", `[{"trait_type": "Background", "value": "Gold"}, {"value": 3}]`,
+			Attributes{{TraitType: "Background", Value: "Gold"}, {Value: float64(3)}}},
+		{"malformed entries", `[{"trait_type": 1, "value": "x"}, "junk", null, {}, {"trait_type": "Eyes", "value": ["a"]}]`,
+			Attributes{{TraitType: "Eyes", Value: []any{"a"}}}},
+		{"object", `{"Eyes": "Laser", "Background": "Gold"}`,
+			Attributes{{TraitType: "Background", Value: "Gold"}, {TraitType: "Eyes", Value: "Laser"}}},
+		{"string", `"none"`, nil},
+		{"null", `null`, nil},
+	} {
+		var metadata Metadata
+		err := json.Unmarshal([]byte(`{"name": "token", "attributes": `+test.json+`}`), &metadata)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if metadata.Name != "token" || !reflect.DeepEqual(metadata.Attributes, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, metadata.Attributes)
+		}
+	}
+}
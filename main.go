@@ -1,21 +1,36 @@
 package main
 
 import (
+	"errors"
+	"expvar"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/ipfs-scrape/worker/postgres"
 	"github.com/ipfs-scrape/worker/processor"
 	"github.com/ipfs-scrape/worker/queue"
+	"github.com/ipfs-scrape/worker/scheduler"
+	"github.com/ipfs-scrape/worker/search"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	// PostgreSQL replaces DynamoDB for the queue and the backend when configured
+	postgresDSN := os.Getenv("IPFS_POSTGRES_DSN")
+
 	dynamodbName, ok := os.LookupEnv("IPFS_DYNAMODB_NAME")
-	if !ok {
+	if !ok && postgresDSN == "" {
 		logrus.Fatal("IPFS_DYNAMODB_NAME environment variable not set")
 	}
 
@@ -42,11 +57,251 @@ func main() {
 		}
 	}
 
+	// CIDs of one item are fetched one at a time unless a fan-out is configured
+	ipfsFetchFanOut, err := strconv.Atoi(os.Getenv("IPFS_FETCH_FANOUT"))
+	if err != nil || ipfsFetchFanOut <= 0 {
+		ipfsFetchFanOut = 1
+	}
+
+	// the number of fetches across all workers is unlimited unless a cap is configured
+	ipfsFetchMaxInFlight, err := strconv.Atoi(os.Getenv("IPFS_FETCH_MAX_IN_FLIGHT"))
+	if err != nil {
+		ipfsFetchMaxInFlight = 0
+	}
+
+	// requests to the gateway are only limited by its Retry-After unless a rate is configured
+	ipfsGatewayRate, err := strconv.ParseFloat(os.Getenv("IPFS_GATEWAY_RATE"), 64)
+	if err != nil {
+		ipfsGatewayRate = 0
+	}
+	ipfsGatewayBurst, err := strconv.Atoi(os.Getenv("IPFS_GATEWAY_BURST"))
+	if err != nil || ipfsGatewayBurst <= 0 {
+		ipfsGatewayBurst = 1
+	}
+
+	// each class of fetch errors is retried by its own policy, see processor.DefaultRetryPolicies
+	ipfsRetryPolicies := processor.DefaultRetryPolicies
+	for env, policy := range map[string]*processor.RetryPolicy{
+		"IPFS_RETRY_TRANSIENT":      &ipfsRetryPolicies.Transient,
+		"IPFS_RETRY_NOT_FOUND":      &ipfsRetryPolicies.Permanent,
+		"IPFS_RETRY_INFRASTRUCTURE": &ipfsRetryPolicies.Infrastructure,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		parsed, err := processor.ParseRetryPolicy(value)
+		if err != nil {
+			logrus.Warnf("Failed to parse %s: %s %v", env, value, err)
+			continue
+		}
+		*policy = parsed
+	}
+
+	// the HTTP client for fetching CIDs, see processor.DefaultClientConfig for the defaults
+	ipfsClientConfig := processor.DefaultClientConfig
+	for env, timeout := range map[string]*time.Duration{
+		"IPFS_FETCH_TIMEOUT":        &ipfsClientConfig.Timeout,
+		"IPFS_FETCH_DIAL_TIMEOUT":   &ipfsClientConfig.DialTimeout,
+		"IPFS_FETCH_TLS_TIMEOUT":    &ipfsClientConfig.TLSHandshakeTimeout,
+		"IPFS_FETCH_HEADER_TIMEOUT": &ipfsClientConfig.ResponseHeaderTimeout,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			logrus.Warnf("Failed to parse %s: %s %v", env, value, err)
+			continue
+		}
+		*timeout = parsed
+	}
+	if maxIdle, err := strconv.Atoi(os.Getenv("IPFS_FETCH_MAX_IDLE_PER_HOST")); err == nil && maxIdle > 0 {
+		ipfsClientConfig.MaxIdleConnsPerHost = maxIdle
+	}
+	// proxy URLs may hold credentials, so only the redacted URL is logged
+	ipfsClientConfig.ProxyURL = os.Getenv("IPFS_FETCH_PROXY")
+	ipfsFetchProxy := ""
+	if ipfsClientConfig.ProxyURL != "" {
+		proxyURL, err := url.Parse(ipfsClientConfig.ProxyURL)
+		if err != nil {
+			// url.Error repeats the URL, so only its cause is logged
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			logrus.Fatalf("Failed to parse IPFS_FETCH_PROXY: %v", err)
+		}
+		ipfsFetchProxy = proxyURL.Redacted()
+	}
+	ipfsClientConfig.CABundle = os.Getenv("IPFS_FETCH_CA_BUNDLE")
+	if userAgent := os.Getenv("IPFS_FETCH_USER_AGENT"); userAgent != "" {
+		ipfsClientConfig.UserAgent = userAgent
+	}
+	// extra headers usually hold gateway API keys, so only their names are logged
+	ipfsClientConfig.Headers, err = processor.ParseHeaders(os.Getenv("IPFS_FETCH_HEADERS"))
+	if err != nil {
+		logrus.Fatalf("Failed to parse IPFS_FETCH_HEADERS: %v", err)
+	}
+	ipfsHTTPClient, err := processor.NewHTTPClient(ipfsClientConfig)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// circuit breakers open after this many failures in a row and probe again after the cooldown
+	ipfsBreakerThreshold, err := strconv.Atoi(os.Getenv("IPFS_BREAKER_THRESHOLD"))
+	if err != nil || ipfsBreakerThreshold <= 0 {
+		ipfsBreakerThreshold = 5
+	}
+	ipfsBreakerCooldown, err := time.ParseDuration(os.Getenv("IPFS_BREAKER_COOLDOWN"))
+	if err != nil || ipfsBreakerCooldown <= 0 {
+		ipfsBreakerCooldown = 30 * time.Second
+	}
+
+	// refreshing stale metadata is off unless a TTL is configured
+	var ipfsRefreshTTL time.Duration
+	ipfsRefreshTTLStr := os.Getenv("IPFS_REFRESH_TTL")
+	if ipfsRefreshTTLStr != "" {
+		ipfsRefreshTTL, err = time.ParseDuration(ipfsRefreshTTLStr)
+		if err != nil {
+			logrus.Warnf("Failed to parse IPFS_REFRESH_TTL: %s %v", ipfsRefreshTTLStr, err)
+			ipfsRefreshTTL = 0
+		}
+	}
+
+	// skipping CIDs that were fetched recently is off unless a duration is configured
+	var ipfsFreshFor time.Duration
+	ipfsFreshForStr := os.Getenv("IPFS_FRESH_FOR")
+	if ipfsFreshForStr != "" {
+		ipfsFreshFor, err = time.ParseDuration(ipfsFreshForStr)
+		if err != nil {
+			logrus.Warnf("Failed to parse IPFS_FRESH_FOR: %s %v", ipfsFreshForStr, err)
+			ipfsFreshFor = 0
+		}
+	}
+
+	ipfsRefreshIntervalStr := os.Getenv("IPFS_REFRESH_INTERVAL")
+	ipfsRefreshInterval := time.Hour
+	if ipfsRefreshIntervalStr != "" {
+		ipfsRefreshInterval, err = time.ParseDuration(ipfsRefreshIntervalStr)
+		if err != nil {
+			logrus.Warnf("Failed to parse IPFS_REFRESH_INTERVAL: %s %v", ipfsRefreshIntervalStr, err)
+			ipfsRefreshInterval = time.Hour
+		}
+	}
+
+	ipfsRefreshMaxJobsStr := os.Getenv("IPFS_REFRESH_MAX_JOBS")
+	ipfsRefreshMaxJobs := 100
+	if ipfsRefreshMaxJobsStr != "" {
+		ipfsRefreshMaxJobs, err = strconv.Atoi(ipfsRefreshMaxJobsStr)
+		if err != nil {
+			logrus.Warnf("Failed to convert IPFS_REFRESH_MAX_JOBS: %s to int: %v", ipfsRefreshMaxJobsStr, err)
+			ipfsRefreshMaxJobs = 100
+		}
+	}
+
+	// mirroring is off unless a secondary table is configured
+	ipfsMirrorDynamodbName := os.Getenv("IPFS_MIRROR_DYNAMODB_NAME")
+	ipfsMirrorPostgresDSN := os.Getenv("IPFS_MIRROR_POSTGRES_DSN")
+	ipfsMirrorRequired := os.Getenv("IPFS_MIRROR_REQUIRED") == "true"
+
+	// caching is off unless a size is configured
+	ipfsCacheSizeStr := os.Getenv("IPFS_CACHE_SIZE")
+	ipfsCacheSize := 0
+	if ipfsCacheSizeStr != "" {
+		ipfsCacheSize, err = strconv.Atoi(ipfsCacheSizeStr)
+		if err != nil {
+			logrus.Warnf("Failed to convert IPFS_CACHE_SIZE: %s to int: %v", ipfsCacheSizeStr, err)
+			ipfsCacheSize = 0
+		}
+	}
+
+	ipfsCacheTTLStr := os.Getenv("IPFS_CACHE_TTL")
+	ipfsCacheTTL := 5 * time.Minute
+	if ipfsCacheTTLStr != "" {
+		ipfsCacheTTL, err = time.ParseDuration(ipfsCacheTTLStr)
+		if err != nil {
+			logrus.Warnf("Failed to parse IPFS_CACHE_TTL: %s %v", ipfsCacheTTLStr, err)
+			ipfsCacheTTL = 5 * time.Minute
+		}
+	}
+
+	ipfsCacheNegativeTTLStr := os.Getenv("IPFS_CACHE_NEGATIVE_TTL")
+	ipfsCacheNegativeTTL := time.Minute
+	if ipfsCacheNegativeTTLStr != "" {
+		ipfsCacheNegativeTTL, err = time.ParseDuration(ipfsCacheNegativeTTLStr)
+		if err != nil {
+			logrus.Warnf("Failed to parse IPFS_CACHE_NEGATIVE_TTL: %s %v", ipfsCacheNegativeTTLStr, err)
+			ipfsCacheNegativeTTL = time.Minute
+		}
+	}
+
+	// the search index is optional and lives on local disk
+	ipfsSearchIndexPath := os.Getenv("IPFS_SEARCH_INDEX_PATH")
+	ipfsSearchRebuild := os.Getenv("IPFS_SEARCH_REBUILD") == "true"
+
+	// the HTTP server is only started if an address is configured
+	ipfsHTTPAddr := os.Getenv("IPFS_HTTP_ADDR")
+
+	// Redis replaces the queue, but not the backend, when configured
+	redisAddr := os.Getenv("IPFS_REDIS_ADDR")
+
+	// SQS replaces the queue, but not the backend, when configured
+	sqsQueueURL := os.Getenv("IPFS_SQS_QUEUE_URL")
+	sqsEndpoint := os.Getenv("IPFS_SQS_ENDPOINT")
+	sqsDeadLetterARN := os.Getenv("IPFS_SQS_DEAD_LETTER_ARN")
+	sqsMaxReceiveCount, err := strconv.Atoi(os.Getenv("IPFS_SQS_MAX_RECEIVE_COUNT"))
+	if err != nil || sqsMaxReceiveCount <= 0 {
+		sqsMaxReceiveCount = 5
+	}
+
 	// Use the IPFS_DYNAMODB_NAME environment variable
 	logrus.Infof("IPFS_DYNAMODB_NAME: %s", dynamodbName)
+	logrus.Infof("IPFS_POSTGRES_DSN set: %t", postgresDSN != "")
 	logrus.Infof("IPFS_GATEWAY_URL: %s", ipfsGatewayURL)
 	logrus.Infof("IPFS_SCRAPE_INTERVAL: %s", ipfsScrapeInterval)
 	logrus.Infof("IPFS_SCRAPE_CONCURRENCY: %d", ipfsScrapeConcurrency)
+	logrus.Infof("IPFS_FETCH_FANOUT: %d", ipfsFetchFanOut)
+	logrus.Infof("IPFS_FETCH_MAX_IN_FLIGHT: %d", ipfsFetchMaxInFlight)
+	logrus.Infof("IPFS_GATEWAY_RATE: %g", ipfsGatewayRate)
+	logrus.Infof("IPFS_GATEWAY_BURST: %d", ipfsGatewayBurst)
+	logrus.Infof("IPFS_RETRY_TRANSIENT: %d:%s", ipfsRetryPolicies.Transient.Attempts, ipfsRetryPolicies.Transient.Backoff)
+	logrus.Infof("IPFS_RETRY_NOT_FOUND: %d:%s", ipfsRetryPolicies.Permanent.Attempts, ipfsRetryPolicies.Permanent.Backoff)
+	logrus.Infof("IPFS_RETRY_INFRASTRUCTURE: %d:%s", ipfsRetryPolicies.Infrastructure.Attempts, ipfsRetryPolicies.Infrastructure.Backoff)
+	logrus.Infof("IPFS_FETCH_TIMEOUT: %s", ipfsClientConfig.Timeout)
+	logrus.Infof("IPFS_FETCH_DIAL_TIMEOUT: %s", ipfsClientConfig.DialTimeout)
+	logrus.Infof("IPFS_FETCH_TLS_TIMEOUT: %s", ipfsClientConfig.TLSHandshakeTimeout)
+	logrus.Infof("IPFS_FETCH_HEADER_TIMEOUT: %s", ipfsClientConfig.ResponseHeaderTimeout)
+	logrus.Infof("IPFS_FETCH_MAX_IDLE_PER_HOST: %d", ipfsClientConfig.MaxIdleConnsPerHost)
+	logrus.Infof("IPFS_FETCH_PROXY: %s", ipfsFetchProxy)
+	logrus.Infof("IPFS_FETCH_CA_BUNDLE: %s", ipfsClientConfig.CABundle)
+	logrus.Infof("IPFS_FETCH_USER_AGENT: %s", ipfsClientConfig.UserAgent)
+	headerNames := []string{}
+	for name := range ipfsClientConfig.Headers {
+		headerNames = append(headerNames, name)
+	}
+	logrus.Infof("IPFS_FETCH_HEADERS: %s", strings.Join(headerNames, ", "))
+	logrus.Infof("IPFS_BREAKER_THRESHOLD: %d", ipfsBreakerThreshold)
+	logrus.Infof("IPFS_BREAKER_COOLDOWN: %s", ipfsBreakerCooldown)
+	logrus.Infof("IPFS_REFRESH_TTL: %s", ipfsRefreshTTL)
+	logrus.Infof("IPFS_FRESH_FOR: %s", ipfsFreshFor)
+	logrus.Infof("IPFS_REFRESH_INTERVAL: %s", ipfsRefreshInterval)
+	logrus.Infof("IPFS_REFRESH_MAX_JOBS: %d", ipfsRefreshMaxJobs)
+	logrus.Infof("IPFS_MIRROR_DYNAMODB_NAME: %s", ipfsMirrorDynamodbName)
+	logrus.Infof("IPFS_MIRROR_POSTGRES_DSN set: %t", ipfsMirrorPostgresDSN != "")
+	logrus.Infof("IPFS_MIRROR_REQUIRED: %t", ipfsMirrorRequired)
+	logrus.Infof("IPFS_CACHE_SIZE: %d", ipfsCacheSize)
+	logrus.Infof("IPFS_CACHE_TTL: %s", ipfsCacheTTL)
+	logrus.Infof("IPFS_CACHE_NEGATIVE_TTL: %s", ipfsCacheNegativeTTL)
+	logrus.Infof("IPFS_SEARCH_INDEX_PATH: %s", ipfsSearchIndexPath)
+	logrus.Infof("IPFS_SEARCH_REBUILD: %t", ipfsSearchRebuild)
+	logrus.Infof("IPFS_HTTP_ADDR: %s", ipfsHTTPAddr)
+	logrus.Infof("IPFS_REDIS_ADDR: %s", redisAddr)
+	logrus.Infof("IPFS_SQS_QUEUE_URL: %s", sqsQueueURL)
+	logrus.Infof("IPFS_SQS_ENDPOINT: %s", sqsEndpoint)
+	logrus.Infof("IPFS_SQS_DEAD_LETTER_ARN: %s", sqsDeadLetterARN)
+	logrus.Infof("IPFS_SQS_MAX_RECEIVE_COUNT: %d", sqsMaxReceiveCount)
 
 	// Create a new session and DynamoDB client
 	sess, err := session.NewSession()
@@ -56,19 +311,189 @@ func main() {
 
 	svc := dynamodb.New(sess)
 
-	// create an instance of our DynamoDBQueue
-	dynamoDBQueue, err := queue.NewDynamoDBQueue(dynamodbName, "ipfs", svc)
-	if err != nil {
-		logrus.Fatal(err)
+	var ipfsQueue queue.Queue
+	var ipfsBackend backend.Backend
+	if postgresDSN != "" {
+		db, err := postgres.Open(postgresDSN)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer db.Close()
+
+		// create an instance of our PostgresQueue
+		ipfsQueue, err = queue.NewPostgresQueue(db, "ipfs")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		// create an instance of our PostgresBackend
+		ipfsBackend, err = backend.NewPostgresBackend(db)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	} else {
+		// create an instance of our DynamoDBQueue
+		ipfsQueue, err = queue.NewDynamoDBQueue(dynamodbName, "ipfs", svc)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		// create an instance of out DynamoDBBackend
+		ipfsBackend, err = backend.NewDynamoDBBackend(dynamodbName, svc)
+		if err != nil {
+			logrus.Fatal(err)
+		}
 	}
 
-	// create an instance of out DynamoDBBackend
-	dynamoDBBackend, err := backend.NewDynamoDBBackend(dynamodbName, svc)
-	if err != nil {
-		logrus.Fatal(err)
+	if redisAddr != "" {
+		// create an instance of our RedisQueue, comma separated addresses select a cluster
+		client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: strings.Split(redisAddr, ",")})
+		defer client.Close()
+
+		ipfsQueue, err = queue.NewRedisQueue(client, "ipfs")
+		if err != nil {
+			logrus.Fatal(err)
+		}
 	}
+
+	if sqsQueueURL != "" {
+		// the endpoint override points the client at a local stand-in like ElasticMQ or localstack
+		sqsConfig := aws.NewConfig()
+		if sqsEndpoint != "" {
+			sqsConfig = sqsConfig.WithEndpoint(sqsEndpoint)
+		}
+
+		// create an instance of our SQSQueue
+		sqsQueue, err := queue.NewSQSQueue(sqsQueueURL, sqs.New(sess, sqsConfig))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if sqsDeadLetterARN != "" {
+			err = sqsQueue.SetRedrivePolicy(sqsDeadLetterARN, sqsMaxReceiveCount)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		ipfsQueue = sqsQueue
+	}
+
+	// wake the processor up when items are added instead of waiting for its next poll.
+	// PostgreSQL notifies every process, otherwise only items added in this process, e.g. refresh jobs, are seen.
+	var ipfsNotifier queue.Notifier
+	if postgresDSN != "" && redisAddr == "" && sqsQueueURL == "" {
+		postgresNotifier, err := queue.NewPostgresNotifier(postgresDSN, "ipfs")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer postgresNotifier.Close()
+		ipfsNotifier = postgresNotifier
+	} else {
+		notifyingQueue := queue.NewNotifyingQueue(ipfsQueue)
+		ipfsQueue = notifyingQueue
+		ipfsNotifier = notifyingQueue
+	}
+
+	// copy every write to secondary stores if mirroring is enabled
+	secondaries := []backend.Secondary{}
+	if ipfsMirrorDynamodbName != "" {
+		mirrorBackend, err := backend.NewDynamoDBBackend(ipfsMirrorDynamodbName, svc)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		secondaries = append(secondaries, backend.Secondary{Backend: mirrorBackend, Required: ipfsMirrorRequired})
+	}
+	if ipfsMirrorPostgresDSN != "" {
+		db, err := postgres.Open(ipfsMirrorPostgresDSN)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer db.Close()
+
+		mirrorBackend, err := backend.NewPostgresBackend(db)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		secondaries = append(secondaries, backend.Secondary{Backend: mirrorBackend, Required: ipfsMirrorRequired})
+	}
+	if len(secondaries) > 0 {
+		ipfsBackend = backend.NewMirrorBackend(ipfsBackend, 1000, secondaries...)
+	}
+
+	// put our cache in front of it if caching is enabled
+	if ipfsCacheSize > 0 {
+		ipfsBackend = backend.NewCachedBackend(ipfsBackend, ipfsCacheSize, ipfsCacheTTL, ipfsCacheNegativeTTL)
+	}
+
+	// export and import run against the backend instead of starting the worker
+	if len(os.Args) > 1 {
+		err = runDump(os.Args[1], os.Args[2:], ipfsBackend)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	// drop CIDs with recently fetched metadata from requeued and scheduled items
+	if ipfsFreshFor > 0 {
+		ipfsQueue = queue.NewFreshCIDQueue(ipfsQueue, ipfsBackend, ipfsFreshFor)
+	}
+
 	// create an instance of our IPFSProcessor
-	ipfsProcessor := processor.NewIPFSProcessor(dynamoDBQueue, dynamoDBBackend, ipfsGatewayURL, ipfsScrapeInterval, ipfsScrapeConcurrency)
+	ipfsProcessor := processor.NewIPFSProcessor(ipfsQueue, ipfsBackend, ipfsGatewayURL, ipfsScrapeInterval, ipfsScrapeConcurrency)
+	ipfsProcessor.SetNotifier(ipfsNotifier)
+	ipfsProcessor.SetFetchLimits(ipfsFetchFanOut, ipfsFetchMaxInFlight)
+	ipfsProcessor.SetRateLimiter(processor.NewRateLimiter(ipfsGatewayRate, ipfsGatewayBurst))
+	ipfsProcessor.SetRetryPolicies(ipfsRetryPolicies)
+	ipfsProcessor.SetBreakerPolicy(ipfsBreakerThreshold, ipfsBreakerCooldown)
+	ipfsProcessor.SetHTTPClient(ipfsHTTPClient)
+
+	mux := http.NewServeMux()
+
+	// health and expvar metrics, including the circuit breakers and the cache
+	expvar.Publish("breakers", expvar.Func(func() any { return ipfsProcessor.BreakerStates() }))
+	if cachedBackend, ok := ipfsBackend.(*backend.CachedBackend); ok {
+		expvar.Publish("cache", expvar.Func(func() any { return cachedBackend.Stats() }))
+	}
+	mux.Handle("/healthz", ipfsProcessor)
+	mux.Handle("/metrics", expvar.Handler())
+
+	// open our search index if one is configured
+	if ipfsSearchIndexPath != "" {
+		searchIndex, err := search.Open(ipfsSearchIndexPath)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer searchIndex.Close()
+
+		if ipfsSearchRebuild {
+			go func() {
+				_, err := searchIndex.Rebuild(backend.NewStore[ipfs.Metadata](ipfsBackend))
+				if err != nil {
+					logrus.WithError(err).Error("Failed to rebuild search index")
+				}
+			}()
+		}
+
+		ipfsProcessor.SetIndexer(searchIndex)
+		mux.Handle("/search", searchIndex)
+	}
+
+	if ipfsHTTPAddr != "" {
+		go func() {
+			logrus.Fatal(http.ListenAndServe(ipfsHTTPAddr, mux))
+		}()
+	}
+
+	// create an instance of our RefreshScheduler if refreshing is enabled
+	if ipfsRefreshTTL > 0 {
+		// without deduplication every scan would enqueue the stale records again until they are refreshed
+		if sqsQueueURL != "" && !strings.HasSuffix(sqsQueueURL, ".fifo") {
+			logrus.Fatal("IPFS_REFRESH_TTL requires a FIFO SQS queue, standard SQS queues can't deduplicate refresh jobs")
+		}
+		refreshScheduler := scheduler.NewRefreshScheduler(ipfsQueue, ipfsBackend, ipfsRefreshTTL, ipfsRefreshInterval, ipfsRefreshMaxJobs)
+		refreshScheduler.Run()
+	}
 
 	// non-blocking start
 	ipfsProcessor.Run()
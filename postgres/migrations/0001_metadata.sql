@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS metadata (
+    id          TEXT PRIMARY KEY,
+    cid         TEXT NOT NULL DEFAULT '',
+    name        TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image       TEXT NOT NULL DEFAULT '',
+    gateway     TEXT NOT NULL DEFAULT '',
+    fetched_at  BIGINT NOT NULL DEFAULT 0,
+    version     BIGINT NOT NULL DEFAULT 1,
+    image_cid   TEXT,
+    name_key    TEXT,
+    data        JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS metadata_image_cid_idx ON metadata (image_cid);
+CREATE INDEX IF NOT EXISTS metadata_name_key_idx ON metadata (name_key);
+
+CREATE TABLE IF NOT EXISTS metadata_attributes (
+    id       TEXT NOT NULL REFERENCES metadata (id) ON DELETE CASCADE,
+    attr_key TEXT NOT NULL,
+    PRIMARY KEY (id, attr_key)
+);
+
+CREATE INDEX IF NOT EXISTS metadata_attributes_attr_key_idx ON metadata_attributes (attr_key);
+
+CREATE TABLE IF NOT EXISTS metadata_history (
+    id      TEXT NOT NULL,
+    version BIGINT NOT NULL,
+    data    JSONB NOT NULL,
+    PRIMARY KEY (id, version)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS queue_items (
+    seq        BIGSERIAL PRIMARY KEY,
+    queue      TEXT NOT NULL,
+    item_id    TEXT NOT NULL,
+    data       JSONB NOT NULL,
+    locked     BOOLEAN NOT NULL DEFAULT FALSE,
+    lock_time  TIMESTAMPTZ,
+    not_before TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS queue_items_queue_idx ON queue_items (queue, seq);
+CREATE INDEX IF NOT EXISTS queue_items_item_id_idx ON queue_items (queue, item_id);
+
+CREATE TABLE IF NOT EXISTS queue_idempotency (
+    queue      TEXT NOT NULL,
+    key        TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (queue, key)
+);
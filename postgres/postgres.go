@@ -0,0 +1,117 @@
+/*
+Package postgres holds the schema shared by the PostgreSQL backend and queue.
+*/
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	// registers the "postgres" driver for database/sql
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Open connects to the database at dsn and migrates its schema to the latest version.
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = Migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrateLockID is the advisory lock that keeps workers starting at the same time from migrating concurrently.
+const migrateLockID = 727447
+
+// Migrate applies every migration that has not been applied to db yet, in order.
+// Each migration runs in its own transaction together with recording its version.
+func Migrate(db *sql.DB) error {
+	logger := logrus.WithField("component", "PostgresMigrate")
+	ctx := context.Background()
+
+	// advisory locks belong to a session, so everything runs on one connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrateLockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrateLockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INT PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, entry := range entries {
+		// migrations are named <version>_<description>.sql
+		version, err := strconv.Atoi(strings.SplitN(entry.Name(), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("invalid migration name %s: %w", entry.Name(), err)
+		}
+		if version <= current {
+			continue
+		}
+
+		script, err := migrations.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(string(script))
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %w", entry.Name(), err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		logger.Infof("Applied migration %s", entry.Name())
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a Breaker.
+type BreakerState int
+
+const (
+	// Closed breakers let every call through.
+	Closed BreakerState = iota
+	// Open breakers let no call through until their cooldown has passed.
+	Open
+	// HalfOpen breakers let a single probe through, its outcome closes or opens the breaker again.
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// MarshalText makes states readable in health and metrics output.
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerOpenError is returned for calls an open Breaker didn't let through.
+type BreakerOpenError struct {
+	Name       string
+	RetryAfter time.Duration
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open, retry after %s", e.Name, e.RetryAfter)
+}
+
+// Breaker is a circuit breaker. It opens after threshold consecutive failures and lets a probe through
+// once cooldown has passed.
+type Breaker struct {
+	Name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// generation changes with every state change, outcomes of calls let through before are ignored
+	generation uint64
+}
+
+// NewBreaker creates a closed Breaker.
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	return &Breaker{
+		Name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns a BreakerOpenError if a call may not go through. A call that was let through must report
+// its outcome with Success or Failure and the generation Allow returned, otherwise a half-open breaker keeps
+// waiting for its probe.
+func (b *Breaker) Allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+			return 0, &BreakerOpenError{Name: b.Name, RetryAfter: wait}
+		}
+		b.setState(HalfOpen)
+		b.probing = true
+		return b.generation, nil
+	case HalfOpen:
+		if b.probing {
+			return 0, &BreakerOpenError{Name: b.Name, RetryAfter: b.cooldown}
+		}
+		b.probing = true
+		return b.generation, nil
+	default:
+		return b.generation, nil
+	}
+}
+
+// setState moves the breaker to state and starts a new generation, b.mu must be held.
+func (b *Breaker) setState(state BreakerState) {
+	b.state = state
+	b.generation++
+}
+
+// Success counts a successful call, a successful probe closes the breaker. Calls that were let through
+// before the breaker last changed its state are ignored, so only the probe decides on a half-open breaker.
+func (b *Breaker) Success(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	if b.state == HalfOpen {
+		b.setState(Closed)
+		b.probing = false
+	}
+	b.failures = 0
+}
+
+// Failure counts a failed call, it opens the breaker after threshold failures in a row or a failed probe.
+// Like in Success, calls that were let through before the breaker last changed its state are ignored,
+// so late failures don't extend the cooldown of an open breaker.
+func (b *Breaker) Failure(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.setState(Open)
+		b.openedAt = time.Now()
+		b.failures = 0
+		b.probing = false
+	}
+}
+
+// State returns the state of the breaker.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// wait returns how long the breaker stays open before it lets a probe through.
+func (b *Breaker) wait() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != Open {
+		return 0
+	}
+	if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker("test", 2, 50*time.Millisecond)
+
+	// a single failure doesn't open the breaker
+	generation, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Failure(generation)
+	if b.State() != Closed {
+		t.Errorf("expected closed after one failure, got %s", b.State())
+	}
+
+	// a call let through while closed
+	late, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Failure(generation)
+	if b.State() != Open {
+		t.Fatalf("expected open after two failures, got %s", b.State())
+	}
+	var open *BreakerOpenError
+	if _, err := b.Allow(); !errors.As(err, &open) {
+		t.Errorf("expected an open breaker to refuse calls, got %v", err)
+	}
+
+	// late outcomes of calls let through before the breaker opened neither close it nor extend its cooldown
+	wait := b.wait()
+	time.Sleep(10 * time.Millisecond)
+	b.Failure(late)
+	if b.wait() >= wait {
+		t.Errorf("expected a late failure to leave the cooldown alone, waiting %s after %s", b.wait(), wait)
+	}
+
+	// after the cooldown a single probe goes through
+	time.Sleep(60 * time.Millisecond)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatalf("expected a probe after the cooldown, got %v", err)
+	}
+	if b.State() != HalfOpen {
+		t.Errorf("expected half-open while probing, got %s", b.State())
+	}
+	if _, err := b.Allow(); err == nil {
+		t.Error("expected a second call to wait for the probe")
+	}
+	b.Success(late)
+	if b.State() != HalfOpen {
+		t.Errorf("expected only the probe to close the breaker, got %s", b.State())
+	}
+
+	// a failed probe opens the breaker again, a successful one closes it
+	b.Failure(probe)
+	if b.State() != Open {
+		t.Errorf("expected open after a failed probe, got %s", b.State())
+	}
+	time.Sleep(60 * time.Millisecond)
+	probe, err = b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Success(probe)
+	if b.State() != Closed {
+		t.Errorf("expected closed after a successful probe, got %s", b.State())
+	}
+}
@@ -0,0 +1,141 @@
+package processor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ClientConfig configures the HTTP client FetchCID uses.
+type ClientConfig struct {
+	// DialTimeout limits connecting to the gateway.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limits the TLS handshake.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits waiting for the response headers once the request is sent.
+	ResponseHeaderTimeout time.Duration
+	// Timeout limits the whole request, including reading the body.
+	Timeout time.Duration
+	// MaxIdleConnsPerHost is how many idle connections to each gateway are kept for reuse.
+	MaxIdleConnsPerHost int
+	// ProxyURL is the HTTP proxy to use, the HTTP_PROXY and HTTPS_PROXY environment variables are used when empty.
+	ProxyURL string
+	// CABundle is the path of a PEM file with certificates to trust in addition to the system's.
+	CABundle string
+	// UserAgent is sent with every request.
+	UserAgent string
+	// Headers are sent with every request, e.g. a gateway API key.
+	Headers http.Header
+}
+
+// DefaultClientConfig is the ClientConfig a processor starts with.
+var DefaultClientConfig = ClientConfig{
+	DialTimeout:           10 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	Timeout:               time.Minute,
+	MaxIdleConnsPerHost:   16,
+	UserAgent:             "ipfs-scrape-worker",
+}
+
+// NewHTTPClient creates an HTTP client from the config.
+func NewHTTPClient(config ClientConfig) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			// url.Error repeats the URL, which may hold credentials
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return nil, fmt.Errorf("invalid proxy URL: %s", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.CABundle != "" {
+		pem, err := os.ReadFile(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+
+	return &http.Client{
+		Timeout: config.Timeout,
+		Transport: &headerTransport{
+			next:      transport,
+			userAgent: config.UserAgent,
+			headers:   config.Headers,
+		},
+	}, nil
+}
+
+// ParseHeaders parses headers written as "Name: value" pairs separated by semicolons,
+// e.g. "Authorization: Bearer token; X-Api-Key: key".
+func ParseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	for _, pair := range strings.Split(s, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", strings.TrimSpace(pair))
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// headerTransport adds the User-Agent and extra headers to every request.
+type headerTransport struct {
+	next      http.RoundTripper
+	userAgent string
+	headers   http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	return t.next.RoundTrip(req)
+}
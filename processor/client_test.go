@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientHeaders(t *testing.T) {
+	var got http.Header
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer gateway.Close()
+
+	headers, err := ParseHeaders("Authorization: Basic secret; x-pinata-gateway-token: key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultClientConfig
+	config.UserAgent = "test-agent"
+	config.Headers = headers
+
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(gateway.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got.Get("User-Agent") != "test-agent" {
+		t.Errorf("expected the configured User-Agent, got %q", got.Get("User-Agent"))
+	}
+	if got.Get("Authorization") != "Basic secret" || got.Get("X-Pinata-Gateway-Token") != "key" {
+		t.Errorf("expected the extra headers, got %v", got)
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer gateway.Close()
+
+	config := DefaultClientConfig
+	config.ResponseHeaderTimeout = 50 * time.Millisecond
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Get(gateway.URL)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("")
+	if err != nil || len(headers) != 0 {
+		t.Errorf("expected no headers, got %v %v", headers, err)
+	}
+
+	_, err = ParseHeaders("no value")
+	if err == nil {
+		t.Error("expected an error for a header without a colon")
+	}
+}
+
+func TestNewHTTPClientInvalidCABundle(t *testing.T) {
+	config := DefaultClientConfig
+	config.CABundle = "testdata/missing.pem"
+	_, err := NewHTTPClient(config)
+	if err == nil {
+		t.Error("expected an error for a missing CA bundle")
+	}
+}
@@ -0,0 +1,209 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// ErrorClass tells how a failed fetch or write should be retried.
+type ErrorClass int
+
+const (
+	// Transient errors, like timeouts, 5xx and 429 responses, are likely to go away on their own.
+	Transient ErrorClass = iota
+	// Permanent errors, like a CID that isn't found or doesn't hold JSON, won't go away by retrying.
+	Permanent
+	// Infrastructure errors come from our own infrastructure, e.g. a throttled DynamoDB table.
+	Infrastructure
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case Permanent:
+		return "permanent"
+	case Infrastructure:
+		return "infrastructure"
+	default:
+		return "transient"
+	}
+}
+
+// FetchError is a classified error returned by FetchCID.
+type FetchError struct {
+	Class ErrorClass
+	// StatusCode is the gateway's response status, if it answered
+	StatusCode int
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("%s fetch error: %s", e.Class, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// notFound reports whether the gateway didn't find the CID. Unlike other permanent errors
+// it's retried, content may just not have reached the gateway yet.
+func (e *FetchError) notFound() bool {
+	return e.StatusCode == 404
+}
+
+// statusError classifies an unexpected response status.
+func statusError(statusCode int) *FetchError {
+	class := Permanent
+	if statusCode >= 500 || statusCode == 408 || statusCode == 429 {
+		class = Transient
+	}
+	return &FetchError{Class: class, StatusCode: statusCode, Err: fmt.Errorf("unexpected status code: %d", statusCode)}
+}
+
+// decodeError classifies an error decoding the gateway's response. A body that isn't valid JSON is permanent,
+// one that was cut off while reading it is transient.
+func decodeError(err error) *FetchError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.EOF) {
+		return &FetchError{Class: Permanent, Err: fmt.Errorf("invalid JSON: %w", err)}
+	}
+	return &FetchError{Class: Transient, Err: err}
+}
+
+// infrastructureErrorCodes are the AWS error codes of throttled requests.
+var infrastructureErrorCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"ThrottlingException":                    true,
+	"RequestLimitExceeded":                   true,
+	"InternalServerError":                    true,
+	"ServiceUnavailable":                     true,
+}
+
+// Classify returns the ErrorClass of err. Errors that aren't FetchErrors or known infrastructure errors are transient.
+func Classify(err error) ErrorClass {
+	var fetchErr *FetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr.Class
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && infrastructureErrorCodes[awsErr.Code()] {
+		return Infrastructure
+	}
+
+	return Transient
+}
+
+// RetryPolicy is how often and how patiently an operation is tried before giving up.
+type RetryPolicy struct {
+	// Attempts is how often the operation is tried in total, at least once.
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles with every further retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// delay returns the wait before the given retry, starting at 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// ParseRetryPolicy parses a policy written as "<attempts>:<backoff>", e.g. "3:1s".
+// MaxBackoff is set to 30 times the backoff.
+func ParseRetryPolicy(s string) (RetryPolicy, error) {
+	attemptsStr, backoffStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return RetryPolicy{}, fmt.Errorf("invalid retry policy %q, expected <attempts>:<backoff>", s)
+	}
+
+	attempts, err := strconv.Atoi(attemptsStr)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	backoff, err := time.ParseDuration(backoffStr)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+
+	return RetryPolicy{Attempts: attempts, Backoff: backoff, MaxBackoff: 30 * backoff}, nil
+}
+
+// RetryPolicies holds the RetryPolicy of every ErrorClass.
+type RetryPolicies struct {
+	Transient RetryPolicy
+	// Permanent applies to CIDs the gateway didn't find. The other permanent errors are given up on right away.
+	Permanent      RetryPolicy
+	Infrastructure RetryPolicy
+}
+
+// DefaultRetryPolicies are the RetryPolicies a processor starts with.
+var DefaultRetryPolicies = RetryPolicies{
+	Transient:      RetryPolicy{Attempts: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second},
+	Permanent:      RetryPolicy{Attempts: 2, Backoff: 10 * time.Second, MaxBackoff: time.Minute},
+	Infrastructure: RetryPolicy{Attempts: 5, Backoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second},
+}
+
+// policy returns the RetryPolicy for err, and false if err shouldn't be retried at all.
+func (p RetryPolicies) policy(err error) (RetryPolicy, bool) {
+	if _, requeued := requeueDelay(err); requeued {
+		// CIDs are requeued instead while the gateway throttles us or is down
+		return RetryPolicy{}, false
+	}
+
+	switch Classify(err) {
+	case Permanent:
+		var fetchErr *FetchError
+		if errors.As(err, &fetchErr) && fetchErr.notFound() {
+			return p.Permanent, true
+		}
+		return RetryPolicy{}, false
+	case Infrastructure:
+		return p.Infrastructure, true
+	default:
+		return p.Transient, true
+	}
+}
+
+// retryInfrastructure runs fn until it succeeds, fails with an error that isn't an infrastructure error,
+// or the Infrastructure policy is used up. Writes that fail for other reasons, e.g. a version conflict, aren't retried.
+func (p RetryPolicies) retryInfrastructure(fn func() error) error {
+	err := fn()
+	for retry := 1; err != nil && Classify(err) == Infrastructure; retry++ {
+		if retry >= p.Infrastructure.Attempts {
+			return err
+		}
+
+		time.Sleep(p.Infrastructure.delay(retry))
+		err = fn()
+	}
+	return err
+}
+
+// retry runs fn until it succeeds or the RetryPolicy for its error is used up, then returns its last error.
+func (p RetryPolicies) retry(fn func() error) error {
+	err := fn()
+	for retry := 1; err != nil; retry++ {
+		policy, ok := p.policy(err)
+		if !ok || retry >= policy.Attempts {
+			return err
+		}
+
+		time.Sleep(policy.delay(retry))
+		err = fn()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestClassify(t *testing.T) {
+	var syntaxErr *json.SyntaxError
+	err := json.Unmarshal([]byte("<html>"), &struct{}{})
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected a syntax error, got %v", err)
+	}
+
+	for _, test := range []struct {
+		err  error
+		want ErrorClass
+	}{
+		{statusError(500), Transient},
+		{statusError(404), Permanent},
+		{statusError(400), Permanent},
+		{decodeError(err), Permanent},
+		{decodeError(io.EOF), Permanent},
+		{decodeError(io.ErrUnexpectedEOF), Transient},
+		{awserr.New("ProvisionedThroughputExceededException", "throttled", nil), Infrastructure},
+		{fmt.Errorf("wrapped: %w", awserr.New("ThrottlingException", "throttled", nil)), Infrastructure},
+		{awserr.New("ConditionalCheckFailedException", "conflict", nil), Transient},
+		{errors.New("connection reset"), Transient},
+	} {
+		if got := Classify(test.err); got != test.want {
+			t.Errorf("%v: expected %s, got %s", test.err, test.want, got)
+		}
+	}
+}
+
+func TestRetryPolicies(t *testing.T) {
+	policies := RetryPolicies{
+		Transient: RetryPolicy{Attempts: 3},
+		Permanent: RetryPolicy{Attempts: 2},
+	}
+
+	for _, test := range []struct {
+		err      error
+		attempts int
+	}{
+		{statusError(500), 3},
+		{statusError(404), 2},
+		{statusError(400), 1},
+		{&ThrottledError{RetryAfter: time.Minute}, 1},
+	} {
+		attempts := 0
+		err := policies.retry(func() error {
+			attempts++
+			return test.err
+		})
+		if err != test.err {
+			t.Errorf("%v: expected the last error, got %v", test.err, err)
+		}
+		if attempts != test.attempts {
+			t.Errorf("%v: expected %d attempts, got %d", test.err, test.attempts, attempts)
+		}
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	policy, err := ParseRetryPolicy("4:2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Attempts != 4 || policy.Backoff != 2*time.Second {
+		t.Errorf("expected 4 attempts with 2s backoff, got %+v", policy)
+	}
+	if policy.delay(1) != 2*time.Second || policy.delay(3) != 8*time.Second {
+		t.Errorf("expected the backoff to double, got %s and %s", policy.delay(1), policy.delay(3))
+	}
+
+	_, err = ParseRetryPolicy("4")
+	if err == nil {
+		t.Error("expected an error for a policy without backoff")
+	}
+}
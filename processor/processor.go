@@ -2,131 +2,781 @@ package processor
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/ipfs-scrape/worker/backend"
 	"github.com/ipfs-scrape/worker/ipfs"
 	"github.com/ipfs-scrape/worker/queue"
 	"github.com/sirupsen/logrus"
 )
 
+// Indexer is notified of every Metadata the processor writes to the backend, e.g. a search index.
+type Indexer interface {
+	Index(metadata ...ipfs.Metadata) error
+}
+
+// failureCache is implemented by backends that remember failed fetches, see backend.CachedBackend.
+type failureCache interface {
+	MarkFailed(id string)
+	RecentlyFailed(id string) bool
+}
+
 // IPFSProcessor is a struct that implements the Processor interface for IPFS.
 type IPFSProcessor struct {
 	queue       queue.Queue
 	backend     backend.Backend
+	store       *backend.Store[ipfs.Metadata]
 	ipfsGateway string
 	logger      *logrus.Entry
 	concurrency int
+	// fanOut is how many CIDs of one item are fetched at the same time
+	fanOut int
+	// inFlight is shared by all workers and holds a token for every running fetch, nil means no limit
+	inFlight chan struct{}
+	indexer  Indexer
+	notifier queue.Notifier
+	limiter  *RateLimiter
+	client   *http.Client
+	retries  RetryPolicies
+
+	// breakers are created on first use, one per gateway and one for backend writes
+	breakersMu       sync.Mutex
+	breakers         map[string]*Breaker
+	breakerThreshold int
+	breakerCooldown  time.Duration
 
 	stopCh chan struct{}
 	doneCh chan struct{}
 
-	pollTime time.Duration
+	// pollTime is the longest the processor waits before polling an empty queue again
+	pollTime    time.Duration
+	minPollTime time.Duration
 }
 
-// NewIPFSProcessor creates a new IPFSProcessor instance with the specified queue, IPFS gateway, and ticker time.
+// defaultBreakerThreshold is how many calls in a row have to fail before a circuit breaker opens.
+const defaultBreakerThreshold = 5
+
+// defaultBreakerCooldown is how long an open circuit breaker waits before it lets a probe through.
+const defaultBreakerCooldown = 30 * time.Second
+
+// minPollTime is how long the processor first waits after the queue came up empty.
+// The wait doubles with every empty poll up to the processor's poll time.
+const minPollTime = 100 * time.Millisecond
+
+// NewIPFSProcessor creates a new IPFSProcessor instance with the specified queue, IPFS gateway, and maximum poll interval.
 func NewIPFSProcessor(q queue.Queue, b backend.Backend, ipfsGateway string, pollTime time.Duration, concurrency int) *IPFSProcessor {
-	return &IPFSProcessor{
+	p := &IPFSProcessor{
 		queue:       q,
 		backend:     b,
+		store:       backend.NewStore[ipfs.Metadata](b),
 		ipfsGateway: ipfsGateway,
 		logger:      logrus.WithField("component", "IPFSProcessor"),
 		pollTime:    pollTime,
+		minPollTime: minPollTime,
 		concurrency: concurrency,
+		fanOut:      1,
+		limiter:     NewRateLimiter(0, 0),
+		client:      defaultClient,
+		retries:     DefaultRetryPolicies,
+
+		breakers:         map[string]*Breaker{},
+		breakerThreshold: defaultBreakerThreshold,
+		breakerCooldown:  defaultBreakerCooldown,
+	}
+	if p.minPollTime > pollTime {
+		p.minPollTime = pollTime
+	}
+	return p
+}
+
+// SetFetchLimits sets how many CIDs of one item are fetched in parallel and how many fetches may run at the same
+// time across all workers. A maxInFlight of zero or less removes the global limit.
+// It must be called before Run.
+func (p *IPFSProcessor) SetFetchLimits(fanOut, maxInFlight int) {
+	if fanOut < 1 {
+		fanOut = 1
+	}
+	p.fanOut = fanOut
+
+	p.inFlight = nil
+	if maxInFlight > 0 {
+		p.inFlight = make(chan struct{}, maxInFlight)
+	}
+}
+
+// defaultClient is the HTTP client of processors that weren't given one, see DefaultClientConfig.
+var defaultClient, _ = NewHTTPClient(DefaultClientConfig)
+
+// SetHTTPClient replaces the HTTP client used to fetch CIDs, see NewHTTPClient. It must be called before Run.
+func (p *IPFSProcessor) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// SetRateLimiter replaces the processor's RateLimiter, which by default only honors the gateways' Retry-After.
+// It must be called before Run.
+func (p *IPFSProcessor) SetRateLimiter(limiter *RateLimiter) {
+	p.limiter = limiter
+}
+
+// SetRetryPolicies replaces the DefaultRetryPolicies of the processor. It must be called before Run.
+func (p *IPFSProcessor) SetRetryPolicies(retries RetryPolicies) {
+	p.retries = retries
+}
+
+// SetBreakerPolicy sets after how many failures in a row a circuit breaker opens and how long it stays open
+// before it lets a probe through. It must be called before Run.
+func (p *IPFSProcessor) SetBreakerPolicy(threshold int, cooldown time.Duration) {
+	p.breakerThreshold = threshold
+	p.breakerCooldown = cooldown
+}
+
+// breaker returns the circuit breaker with the given name, creating it on first use.
+func (p *IPFSProcessor) breaker(name string) *Breaker {
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+
+	b, ok := p.breakers[name]
+	if !ok {
+		b = NewBreaker(name, p.breakerThreshold, p.breakerCooldown)
+		p.breakers[name] = b
+	}
+	return b
+}
+
+// BreakerStates returns the state of every circuit breaker by name.
+func (p *IPFSProcessor) BreakerStates() map[string]BreakerState {
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+
+	states := make(map[string]BreakerState, len(p.breakers))
+	for name, b := range p.breakers {
+		states[name] = b.State()
+	}
+	return states
+}
+
+// breakerWait returns how long the processor should hold off claiming items because a circuit breaker is open.
+func (p *IPFSProcessor) breakerWait() time.Duration {
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+
+	var wait time.Duration
+	for _, b := range p.breakers {
+		if w := b.wait(); w > wait {
+			wait = w
+		}
 	}
+	return wait
 }
 
+// recovering reports whether a circuit breaker isn't closed, e.g. because it waits for its probe.
+func (p *IPFSProcessor) recovering() bool {
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+
+	for _, b := range p.breakers {
+		if b.State() != Closed {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP reports the processor's health as JSON. It answers with 503 while a circuit breaker is open.
+func (p *IPFSProcessor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	states := p.BreakerStates()
+
+	status := "ok"
+	for _, state := range states {
+		if state == Open {
+			status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":   status,
+		"breakers": states,
+	})
+}
+
+// SetNotifier makes the processor poll the queue right away whenever the Notifier signals new items.
+func (p *IPFSProcessor) SetNotifier(notifier queue.Notifier) {
+	p.notifier = notifier
+}
+
+// notifications returns the Notifier's channel, or nil which never delivers if there is none.
+func (p *IPFSProcessor) notifications() <-chan struct{} {
+	if p.notifier == nil {
+		return nil
+	}
+	return p.notifier.Notifications()
+}
+
+// SetIndexer makes the processor pass every Metadata it writes to the given Indexer.
+func (p *IPFSProcessor) SetIndexer(indexer Indexer) {
+	p.indexer = indexer
+}
+
+// index passes written Metadata to the Indexer, if any. Indexing failures don't fail the item.
+func (p *IPFSProcessor) index(metadata ...ipfs.Metadata) {
+	if p.indexer == nil {
+		return
+	}
+
+	err := p.indexer.Index(metadata...)
+	if err != nil {
+		p.logger.WithError(err).Warnf("Failed to index %d CIDs", len(metadata))
+	}
+}
+
+// failedItemDelay is how long an item whose work failed waits before it is handed out again.
+const failedItemDelay = 5 * time.Minute
+
 // Run starts the IPFSProcessor and processes items from the queue.
+//
+// Every item is processed in its own goroutine that holds one of concurrency worker slots.
+// Items are only claimed from the queue when a slot is free, so a claimed item never waits for a worker
+// and the queue's lock timeout only matters when a worker dies.
 func (p *IPFSProcessor) Run() {
-	// Create channels for receiving items from the queue and stopping the processor
-	itemCh := make(chan queue.QueueItem)
 	p.stopCh = make(chan struct{})
 	p.doneCh = make(chan struct{})
+	p.logger.Infof("started processor with %d worker slots", p.concurrency)
 
-	// Start a ticker to periodically check the queue for new items
-	ticker := time.NewTicker(p.pollTime)
+	go p.dispatch()
+}
 
-	// Start a goroutine to receive items from the queue and send them to the item channel
-	for i := 0; i < p.concurrency; i++ {
-		p.logger.Infof("started processor #%d", i)
+// dispatch claims items for free worker slots until the processor is stopped, then waits for the busy workers.
+// It polls back-to-back while the queue returns items and backs off exponentially while it is empty.
+func (p *IPFSProcessor) dispatch() {
+	// slots holds a token for every busy worker
+	slots := make(chan struct{}, p.concurrency)
+	var workers sync.WaitGroup
 
-		go func() {
-			for item := range itemCh {
-				p.logger.WithField("ID", item.ID).Info("Processing item")
+	defer close(p.doneCh)
+	defer workers.Wait()
 
-				err := p.Work(item)
-				if err != nil {
-					p.logger.WithError(err).Error("Failed to process item")
-				} else {
-					err = p.queue.Done(item)
-					if err != nil {
-						p.logger.WithError(err).Error("Failed to mark item as done")
-					} else {
-						p.logger.WithField("ID", item.ID).Info("Item processed and marked as done")
-					}
-				}
+	wait := p.minPollTime
+	for {
+		if p.stopping() {
+			p.logger.Info("Stopping IPFSProcessor")
+			return
+		}
+
+		// don't claim items while a gateway or the backend is down, they would only fail or be requeued.
+		// While a breaker waits for its probe a single item is worked on at a time.
+		breakerWait := p.breakerWait()
+		recovering := p.recovering()
+		if breakerWait == 0 && recovering && len(slots) > 0 {
+			breakerWait = p.minPollTime
+		}
+		if breakerWait > 0 {
+			if breakerWait > p.pollTime {
+				breakerWait = p.pollTime
 			}
-		}()
-	}
+			p.logger.WithField("wait", breakerWait).Warn("Circuit breaker not closed, not claiming items")
 
-	// Start a goroutine to process items from the item channel
-	go func() {
-		for {
+			timer := time.NewTimer(breakerWait)
 			select {
-			case <-ticker.C:
-				p.logger.Info("Checking queue for new items")
-				item, err := p.queue.GetNextItem()
-				if err != nil {
-					p.logger.WithError(err).Info("did not get an item from queue")
-					continue
+			case <-timer.C:
+			case <-p.stopCh:
+				timer.Stop()
+			}
+			continue
+		}
+
+		// wait for a free slot, then take every other free one
+		select {
+		case slots <- struct{}{}:
+		case <-p.stopCh:
+			continue
+		}
+		max := p.concurrency
+		if recovering {
+			max = 1
+		}
+		free := 1
+	acquire:
+		for free < max {
+			select {
+			case slots <- struct{}{}:
+				free++
+			default:
+				break acquire
+			}
+		}
+
+		p.logger.WithField("max", free).Debug("Checking queue for new items")
+		items, err := p.queue.GetNextItems(free)
+		if err != nil {
+			p.logger.WithError(err).Info("did not get an item from queue")
+		}
+
+		// hand back the slots that didn't get an item
+		for i := len(items); i < free; i++ {
+			<-slots
+		}
+
+		if len(items) > 0 {
+			wait = p.minPollTime
+
+			// items claimed while stopping are released right away instead of waiting for their lock to expire
+			if p.stopping() {
+				p.release(items)
+				for range items {
+					<-slots
 				}
+				continue
+			}
 
-				itemCh <- item
+			for _, item := range items {
+				workers.Add(1)
+				go func(item queue.QueueItem) {
+					defer workers.Done()
+					defer func() { <-slots }()
+					p.process(item)
+				}(item)
+			}
+			continue
+		}
 
-			case <-p.stopCh:
-				p.logger.Info("Stopping IPFSProcessor")
-				close(p.doneCh)
-				return
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			wait *= 2
+			if wait > p.pollTime {
+				wait = p.pollTime
 			}
+
+		case <-p.notifications():
+			timer.Stop()
+			wait = p.minPollTime
+
+		case <-p.stopCh:
+			timer.Stop()
 		}
-	}()
+	}
+}
+
+// stopping reports whether Stop was called.
+func (p *IPFSProcessor) stopping() bool {
+	select {
+	case <-p.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// release hands claimed items back to the queue so another worker can pick them up immediately.
+func (p *IPFSProcessor) release(items []queue.QueueItem) {
+	for _, item := range items {
+		err := p.queue.Nack(item, 0)
+		if err != nil {
+			p.logger.WithError(err).WithField("ID", item.ID).Error("Failed to release item")
+		}
+	}
+	p.logger.Infof("Released %d claimed items", len(items))
+}
+
+// process works on a claimed item and marks it as done, or releases it for a retry if the work failed.
+// If some of its CIDs were requeued because a gateway throttled us, only the failed CIDs are retried
+// as a new item and the item is marked as done, so the requeued CIDs aren't fetched twice.
+func (p *IPFSProcessor) process(item queue.QueueItem) {
+	p.logger.WithField("ID", item.ID).Info("Processing item")
+
+	result, err := p.Work(item)
+	logger := p.logger.WithFields(logrus.Fields{
+		"ID":        item.ID,
+		"fetched":   result.Fetched,
+		"skipped":   result.Skipped,
+		"failed":    len(result.Failed),
+		"requeued":  len(result.Requeued),
+		"permanent": len(result.Permanent),
+	})
+	if err != nil && len(result.Requeued) > 0 && len(result.Failed) > 0 {
+		// the throttled CIDs are queued already, only the failed ones are retried as a new item
+		logger.WithError(err).Error("Failed to process item, retrying failed CIDs")
+		err = p.requeue(item, result.Failed, failedItemDelay)
+		if err != nil {
+			logger.WithError(err).Error("Failed to requeue failed CIDs")
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to process item")
+		err = p.queue.Nack(item, failedItemDelay)
+		if err != nil {
+			logger.WithError(err).Error("Failed to release item")
+		}
+		return
+	}
+
+	err = p.queue.Done(item)
+	if err != nil {
+		logger.WithError(err).Error("Failed to mark item as done")
+	} else {
+		logger.Info("Item processed and marked as done")
+	}
+}
+
+// existsBatchSize is how many CIDs are checked against the backend at once before fetching.
+const existsBatchSize = 100
+
+// writeBatchSize is how many fetched Metadata are buffered before they are written to the backend.
+const writeBatchSize = 25
+
+// Result summarizes what Work did with a queue item.
+type Result struct {
+	// Fetched is the number of CIDs fetched from the gateway and written to the backend.
+	Fetched int
+	// Skipped is the number of CIDs that already had Metadata in the backend.
+	Skipped int
+	// Failed lists the CIDs that could not be fetched or stored.
+	Failed []string
+	// Requeued lists the CIDs a gateway throttled, they were added to the queue again as a scheduled item.
+	Requeued []string
+	// Permanent lists the CIDs that failed permanently. They are stored as negative records and don't fail the item.
+	Permanent []string
 }
-func (p *IPFSProcessor) Work(item queue.QueueItem) error {
-	failures := []string{}
+
+// Work fetches and stores the Metadata for every CID in the item.
+// CIDs that already have Metadata in the backend are skipped unless the item sets "force".
+func (p *IPFSProcessor) Work(item queue.QueueItem) (Result, error) {
+	result := Result{Failed: []string{}, Requeued: []string{}, Permanent: []string{}}
+
+	force, _ := item.Data["force"].(bool)
+	failures, cachesFailures := p.backend.(failureCache)
+	var retryAfter time.Duration
+
+	// fetched Metadata is buffered and written in batches
+	pending := make([]ipfs.Metadata, 0, writeBatchSize)
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		err := p.write(func() error { return p.store.PutBatch(pending) })
+		var conflict *backend.BatchConflictError
+		var partial *backend.BatchFailedError
+		var takenIDs, failedIDs []string
+		switch {
+		case errors.As(err, &partial):
+			p.logger.WithError(err).Errorf("Failed to create %d of %d CIDs in backend", len(partial.IDs), len(pending))
+			takenIDs, failedIDs = partial.Conflicts, partial.IDs
+		case errors.As(err, &conflict):
+			takenIDs = conflict.IDs
+		case err != nil:
+			p.logger.WithError(err).Errorf("Failed to create %d CIDs in backend", len(pending))
+			for _, metadata := range pending {
+				result.Failed = append(result.Failed, metadata.CID)
+			}
+			pending = pending[:0]
+			return
+		}
+
+		// records someone else created in the meantime are kept, records that weren't created are retried
+		created := pending
+		if len(takenIDs) > 0 || len(failedIDs) > 0 {
+			taken := make(map[string]bool, len(takenIDs))
+			for _, id := range takenIDs {
+				taken[id] = true
+			}
+			failed := make(map[string]bool, len(failedIDs))
+			for _, id := range failedIDs {
+				failed[id] = true
+			}
+			created = make([]ipfs.Metadata, 0, len(pending))
+			for _, metadata := range pending {
+				switch {
+				case taken[metadata.ID]:
+					result.Skipped++
+				case failed[metadata.ID]:
+					result.Failed = append(result.Failed, metadata.CID)
+				default:
+					created = append(created, metadata)
+				}
+			}
+		}
+		result.Fetched += len(created)
+		p.index(created...)
+		pending = pending[:0]
+	}
+
 	// We assume the Data Payload for this type of work is the json array of CIDs
 	if anyAny, ok := item.Data["cids"]; ok {
 		cidsAnySlice := anyAny.([]any)
+		cids := make([]string, 0, len(cidsAnySlice))
 		for _, cidAny := range cidsAnySlice {
-			cid := cidAny.(string)
-			metadata, err := p.FetchCID(cid)
-			if err != nil {
-				p.logger.WithError(err).WithField("CID", cid).Error("Failed to fetch CID metadata")
-				failures = append(failures, cid)
-				continue
+			cids = append(cids, cidAny.(string))
+		}
+
+		for start := 0; start < len(cids); start += existsBatchSize {
+			end := start + existsBatchSize
+			if end > len(cids) {
+				end = len(cids)
 			}
+			batch := cids[start:end]
 
-			err = p.backend.Create(metadata)
+			// without knowing what exists, a fetch could only overwrite records or be thrown away
+			existing, err := p.existing(batch)
 			if err != nil {
-				p.logger.WithError(err).WithField("CID", cid).Error("Failed to create CID in backend")
-				failures = append(failures, cid)
+				p.logger.WithError(err).Errorf("Failed to check backend for %d existing CIDs", len(batch))
+				result.Failed = append(result.Failed, batch...)
 				continue
 			}
 
+			// decide which CIDs need fetching before fetching them in parallel
+			jobs := make([]fetchJob, 0, len(batch))
+			for _, cid := range batch {
+				// Content-addressed data never changes, so a stored CID is complete
+				previous, exists := existing[ipfs.GenerateIDFromCID(cid)]
+				if exists && !force {
+					result.Skipped++
+					continue
+				}
+
+				// don't hammer the gateway with CIDs that just failed
+				if cachesFailures && failures.RecentlyFailed(ipfs.GenerateIDFromCID(cid)) {
+					p.logger.WithField("CID", cid).Info("CID failed recently, not fetching")
+					result.Failed = append(result.Failed, cid)
+					continue
+				}
+
+				jobs = append(jobs, fetchJob{cid: cid, previous: previous, exists: exists})
+			}
+
+			for fetched := range p.fetchAll(jobs) {
+				cid, metadata, err := fetched.cid, fetched.metadata, fetched.err
+
+				// throttled CIDs aren't failures, they are tried again once the gateway lets us
+				if delay, ok := requeueDelay(err); ok {
+					p.logger.WithError(err).WithField("CID", cid).Warn("Gateway unavailable for CID fetch")
+					result.Requeued = append(result.Requeued, cid)
+					if delay > retryAfter {
+						retryAfter = delay
+					}
+					continue
+				}
+				// permanent failures are stored as negative records, so they aren't fetched again unless forced
+				if err != nil && Classify(err) == Permanent {
+					p.logger.WithError(err).WithField("CID", cid).Warn("CID failed permanently")
+					result.Permanent = append(result.Permanent, cid)
+					if fetched.exists {
+						p.attempted(fetched.previous, err)
+						continue
+					}
+					pending = append(pending, ipfs.Metadata{
+						ID:        ipfs.GenerateIDFromCID(cid),
+						CID:       cid,
+						FetchedAt: time.Now().Unix(),
+						Gateway:   p.ipfsGateway,
+						Error:     err.Error(),
+					})
+					if len(pending) == writeBatchSize {
+						flush()
+					}
+					continue
+				}
+				if err != nil {
+					p.logger.WithError(err).WithField("CID", cid).Error("Failed to fetch CID metadata")
+					result.Failed = append(result.Failed, cid)
+					if cachesFailures {
+						failures.MarkFailed(ipfs.GenerateIDFromCID(cid))
+					}
+					continue
+				}
+
+				// refreshes go through Update so a concurrent refresh of the same record is detected
+				if fetched.exists {
+					metadata.Version = fetched.previous.Version
+					err = p.write(func() error { return p.store.Update(metadata) })
+					if err != nil {
+						p.logger.WithError(err).WithField("CID", cid).Error("Failed to update CID in backend")
+						result.Failed = append(result.Failed, cid)
+						continue
+					}
+					result.Fetched++
+					p.index(metadata)
+					continue
+				}
+
+				pending = append(pending, metadata)
+				if len(pending) == writeBatchSize {
+					flush()
+				}
+			}
+		}
+	}
+	flush()
+
+	if len(result.Requeued) > 0 {
+		err := p.requeue(item, result.Requeued, retryAfter)
+		if err != nil {
+			p.logger.WithError(err).Errorf("Failed to requeue %d throttled CIDs", len(result.Requeued))
+			result.Failed = append(result.Failed, result.Requeued...)
+			result.Requeued = []string{}
 		}
 	}
 
-	if len(failures) > 0 {
-		return fmt.Errorf("failed to fetch at least CID metadata for:\n%s", strings.Join(failures, "\n"))
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("failed to fetch at least CID metadata for:\n%s", strings.Join(result.Failed, "\n"))
 	}
 
-	return nil
+	return result, nil
 }
 
-// Wait waits for the IPFSProcessor to stop.
+// requeueDelay returns when a CID that failed with err should be tried again, if it failed because the gateway
+// throttled us or its circuit breaker is open rather than because of the CID.
+func requeueDelay(err error) (time.Duration, bool) {
+	var throttled *ThrottledError
+	if errors.As(err, &throttled) {
+		return throttled.RetryAfter, true
+	}
+	var open *BreakerOpenError
+	if errors.As(err, &open) {
+		return open.RetryAfter, true
+	}
+	return 0, false
+}
+
+// write runs a backend write through the backend's circuit breaker and retries it on infrastructure errors.
+// Conflicts are the caller's problem and don't count as failures of the backend.
+func (p *IPFSProcessor) write(fn func() error) error {
+	breaker := p.breaker("backend")
+	generation, err := breaker.Allow()
+	if err != nil {
+		return err
+	}
+
+	err = p.retries.retryInfrastructure(fn)
+	if err != nil && !errors.Is(err, backend.ErrVersionConflict) && !errors.Is(err, backend.ErrAlreadyExists) &&
+		!errors.Is(err, backend.ErrNotFound) {
+		breaker.Failure(generation)
+	} else {
+		breaker.Success(generation)
+	}
+	return err
+}
+
+// requeue adds a new item for the given CIDs of item to the queue that is handed out after delay.
+func (p *IPFSProcessor) requeue(item queue.QueueItem, cids []string, delay time.Duration) error {
+	data := make(map[string]any, len(item.Data))
+	for key, value := range item.Data {
+		data[key] = value
+	}
+	cidsAny := make([]any, 0, len(cids))
+	for _, cid := range cids {
+		cidsAny = append(cidsAny, cid)
+	}
+	data["cids"] = cidsAny
+
+	retry := queue.NewScheduledQueueItem("retry-"+uuid.New().String(), data, time.Now().Add(delay))
+	return p.queue.AddItem(retry)
+}
+
+// attempted records a refresh of an existing record that failed permanently. Good metadata isn't replaced
+// by a negative record, but its FetchedAt is bumped so the record isn't picked for a refresh again right away.
+// A negative record also gets the new error.
+func (p *IPFSProcessor) attempted(previous ipfs.Metadata, fetchErr error) {
+	previous.FetchedAt = time.Now().Unix()
+	if previous.Error != "" {
+		previous.Error = fetchErr.Error()
+		previous.Gateway = p.ipfsGateway
+	}
+
+	err := p.write(func() error { return p.store.Update(previous) })
+	if err != nil {
+		p.logger.WithError(err).WithField("CID", previous.CID).Error("Failed to record refresh attempt in backend")
+	}
+}
+
+// fetchJob is a CID to fetch, together with its record in the backend if it has one.
+type fetchJob struct {
+	cid      string
+	previous ipfs.Metadata
+	exists   bool
+}
+
+// fetchResult is the outcome of a fetchJob.
+type fetchResult struct {
+	fetchJob
+	metadata ipfs.Metadata
+	err      error
+}
+
+// fetchAll fetches the CIDs of the jobs in parallel, at most fanOut at a time for this call and at most
+// maxInFlight at a time across all workers. The returned channel is closed once every job is done.
+func (p *IPFSProcessor) fetchAll(jobs []fetchJob) <-chan fetchResult {
+	results := make(chan fetchResult)
+	fanOut := make(chan struct{}, p.fanOut)
+
+	go func() {
+		var wg sync.WaitGroup
+		for _, job := range jobs {
+			fanOut <- struct{}{}
+			wg.Add(1)
+			go func(job fetchJob) {
+				defer wg.Done()
+
+				var metadata ipfs.Metadata
+				err := p.retries.retry(func() error {
+					var err error
+					metadata, err = p.fetchLimited(job.cid)
+					return err
+				})
+
+				// free the slot before waiting for the result to be consumed
+				<-fanOut
+				results <- fetchResult{fetchJob: job, metadata: metadata, err: err}
+			}(job)
+		}
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// fetchLimited fetches a CID while holding an inFlight token, the token isn't held while retries back off.
+func (p *IPFSProcessor) fetchLimited(cid string) (ipfs.Metadata, error) {
+	if p.inFlight != nil {
+		p.inFlight <- struct{}{}
+		defer func() { <-p.inFlight }()
+	}
+
+	return p.FetchCID(cid)
+}
+
+// existing returns the Metadata already in the backend for the given CIDs, keyed by ID.
+func (p *IPFSProcessor) existing(cids []string) (map[string]ipfs.Metadata, error) {
+	ids := make([]string, 0, len(cids))
+	for _, cid := range cids {
+		ids = append(ids, ipfs.GenerateIDFromCID(cid))
+	}
+
+	records, err := p.store.GetBatch(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]ipfs.Metadata, len(records))
+	for _, metadata := range records {
+		existing[metadata.ID] = metadata
+	}
+
+	return existing, nil
+}
+
+// Wait waits for the IPFSProcessor to stop and for the items being processed to finish.
 func (p *IPFSProcessor) Wait() {
 	<-p.doneCh
 }
@@ -136,6 +786,9 @@ func (p *IPFSProcessor) Stop() {
 	close(p.stopCh)
 }
 
+// maxThrottleWait is the longest FetchCID waits for the RateLimiter before it gives up with a ThrottledError.
+const maxThrottleWait = 5 * time.Second
+
 // FetchCID fetches the content of the specified CID from the IPFS gateway and returns it as an ipfs.Metadata struct.
 func (p *IPFSProcessor) FetchCID(cid string) (ipfs.Metadata, error) {
 	// Verify that the IPFS gateway URL is well-formed
@@ -147,28 +800,59 @@ func (p *IPFSProcessor) FetchCID(cid string) (ipfs.Metadata, error) {
 		return ipfs.Metadata{}, fmt.Errorf("missing protocol in IPFS gateway URL")
 	}
 
+	// don't wait long for a gateway that throttles us, the CID is requeued instead
+	wait, ok := p.limiter.Take(p.ipfsGateway, maxThrottleWait)
+	if !ok {
+		return ipfs.Metadata{}, &ThrottledError{Gateway: p.ipfsGateway, RetryAfter: wait}
+	}
+
+	// nor ask a gateway that is down
+	breaker := p.breaker("gateway " + p.ipfsGateway)
+	generation, err := breaker.Allow()
+	if err != nil {
+		return ipfs.Metadata{}, err
+	}
+
 	// Construct the URL for the specified CID
 	cidURL := fmt.Sprintf("%s/%s", p.ipfsGateway, cid)
 	p.logger.WithField("url", cidURL).Info("Fetching CID")
 
 	// Send an HTTP GET request to the CID URL
-	resp, err := http.Get(cidURL)
+	resp, err := p.client.Get(cidURL)
 	if err != nil {
-		return ipfs.Metadata{}, err
+		breaker.Failure(generation)
+		return ipfs.Metadata{}, &FetchError{Class: Transient, Err: err}
 	}
 	defer resp.Body.Close()
 
+	// a gateway that answers is up, even if it throttles us or doesn't have the CID
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		breaker.Failure(generation)
+	default:
+		breaker.Success(generation)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		delay := retryAfter(resp, time.Now())
+		p.limiter.Throttled(p.ipfsGateway, delay)
+		return ipfs.Metadata{}, &ThrottledError{Gateway: p.ipfsGateway, StatusCode: resp.StatusCode, RetryAfter: delay}
+	}
+	p.limiter.Succeeded(p.ipfsGateway)
+
 	if resp.StatusCode != http.StatusOK {
-		return ipfs.Metadata{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return ipfs.Metadata{}, statusError(resp.StatusCode)
 	}
 
 	var metadata ipfs.Metadata
 	err = json.NewDecoder(resp.Body).Decode(&metadata)
 	if err != nil {
-		return ipfs.Metadata{}, err
+		return ipfs.Metadata{}, decodeError(err)
 	}
 
 	metadata.ID = ipfs.GenerateIDFromCID(cid)
 	metadata.CID = cid
+	metadata.FetchedAt = time.Now().Unix()
+	metadata.Gateway = p.ipfsGateway
 	return metadata, nil
 }
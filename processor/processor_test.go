@@ -0,0 +1,532 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/ipfs-scrape/worker/queue"
+)
+
+// memoryQueue hands out its items in order and records what happens to them.
+type memoryQueue struct {
+	mu      sync.Mutex
+	items   []queue.QueueItem
+	claimed int
+	done    int
+	nacked  int
+}
+
+func (q *memoryQueue) AddItem(item queue.QueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, item)
+	return nil
+}
+
+func (q *memoryQueue) GetNextItem() (queue.QueueItem, error) {
+	items, _ := q.GetNextItems(1)
+	if len(items) == 0 {
+		return queue.QueueItem{}, fmt.Errorf("no items available in queue")
+	}
+	return items[0], nil
+}
+
+func (q *memoryQueue) GetNextItems(max int) ([]queue.QueueItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if max > len(q.items) {
+		max = len(q.items)
+	}
+	items := q.items[:max]
+	q.items = q.items[max:]
+	q.claimed += len(items)
+	return items, nil
+}
+
+func (q *memoryQueue) Done(item queue.QueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.done++
+	return nil
+}
+
+func (q *memoryQueue) Nack(item queue.QueueItem, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nacked++
+	q.items = append(q.items, item)
+	return nil
+}
+
+func (q *memoryQueue) Contains(id string) (bool, error) {
+	return false, nil
+}
+
+func (q *memoryQueue) counts() (claimed, done, nacked int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.claimed, q.done, q.nacked
+}
+
+// emptyBackend has no records and accepts every write.
+type emptyBackend struct {
+	backend.Backend
+}
+
+func (b emptyBackend) ReadBatch(ids []string) ([]any, error) {
+	return []any{}, nil
+}
+
+func (b emptyBackend) CreateBatch(items []any) error {
+	return nil
+}
+
+func TestRunClaimsOnlyForFreeSlots(t *testing.T) {
+	// the gateway holds every request until it is released
+	release := make(chan struct{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, `{"name": "token"}`)
+	}))
+	defer gateway.Close()
+
+	q := &memoryQueue{}
+	for i := 0; i < 5; i++ {
+		q.AddItem(queue.NewQueueItem(fmt.Sprint(i), map[string]any{"cids": []any{fmt.Sprint("cid", i)}}))
+	}
+
+	p := NewIPFSProcessor(q, emptyBackend{}, gateway.URL, 10*time.Millisecond, 2)
+	p.Run()
+
+	time.Sleep(100 * time.Millisecond)
+	claimed, _, _ := q.counts()
+	if claimed != 2 {
+		t.Errorf("expected 2 items claimed for 2 busy workers, got %d", claimed)
+	}
+
+	// the busy workers finish their items after Stop and no more items are claimed
+	p.Stop()
+	close(release)
+	p.Wait()
+
+	claimed, done, nacked := q.counts()
+	if claimed != 2 || done != 2 || nacked != 0 {
+		t.Errorf("expected 2 items claimed and done, got %d claimed, %d done, %d nacked", claimed, done, nacked)
+	}
+}
+
+func TestRunClaimsSingleItemWhileProbing(t *testing.T) {
+	release := make(chan struct{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, `{"name": "token"}`)
+	}))
+	defer gateway.Close()
+
+	q := &memoryQueue{}
+	for i := 0; i < 5; i++ {
+		q.AddItem(queue.NewQueueItem(fmt.Sprint(i), map[string]any{"cids": []any{fmt.Sprint("cid", i)}}))
+	}
+
+	p := NewIPFSProcessor(q, emptyBackend{}, gateway.URL, 10*time.Millisecond, 3)
+	p.SetBreakerPolicy(1, 10*time.Millisecond)
+
+	// open the gateway breaker and let its cooldown pass
+	breaker := p.breaker("gateway " + gateway.URL)
+	generation, _ := breaker.Allow()
+	breaker.Failure(generation)
+	time.Sleep(20 * time.Millisecond)
+
+	p.Run()
+	time.Sleep(100 * time.Millisecond)
+	claimed, _, _ := q.counts()
+	if claimed != 1 {
+		t.Errorf("expected a single item claimed for the probe, got %d", claimed)
+	}
+
+	// once the probe succeeded the free workers are used again
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+	p.Stop()
+	p.Wait()
+
+	claimed, done, _ := q.counts()
+	if claimed != 5 || done != 5 {
+		t.Errorf("expected all 5 items claimed and done, got %d claimed and %d done", claimed, done)
+	}
+}
+
+func TestWorkFetchLimits(t *testing.T) {
+	// the gateway records the most requests it served at the same time
+	var mu sync.Mutex
+	running, most := 0, 0
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		fmt.Fprint(w, `{"name": "token"}`)
+	}))
+	defer gateway.Close()
+
+	cids := []any{}
+	for i := 0; i < 20; i++ {
+		cids = append(cids, fmt.Sprint("cid", i))
+	}
+
+	for _, limits := range []struct{ fanOut, maxInFlight, want int }{
+		{fanOut: 1, maxInFlight: 0, want: 1},
+		{fanOut: 4, maxInFlight: 0, want: 4},
+		{fanOut: 8, maxInFlight: 2, want: 2},
+	} {
+		most = 0
+		p := NewIPFSProcessor(&memoryQueue{}, emptyBackend{}, gateway.URL, time.Second, 1)
+		p.SetFetchLimits(limits.fanOut, limits.maxInFlight)
+
+		result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": cids}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Fetched != len(cids) {
+			t.Errorf("expected %d CIDs fetched, got %d", len(cids), result.Fetched)
+		}
+		if most != limits.want {
+			t.Errorf("fan-out %d, max in flight %d: expected %d parallel fetches, got %d",
+				limits.fanOut, limits.maxInFlight, limits.want, most)
+		}
+	}
+}
+
+func TestWorkRequeuesThrottledCIDs(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"name": "token"}`)
+	}))
+	defer gateway.Close()
+
+	q := &memoryQueue{}
+	p := NewIPFSProcessor(q, emptyBackend{}, gateway.URL, time.Second, 1)
+
+	result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": []any{"fast", "slow"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Fetched != 1 || len(result.Requeued) != 1 || result.Requeued[0] != "slow" {
+		t.Errorf("expected fast to be fetched and slow to be requeued, got %+v", result)
+	}
+
+	if len(q.items) != 1 {
+		t.Fatalf("expected a requeued item, got %d", len(q.items))
+	}
+	retry := q.items[0]
+	if retry.NotBefore < time.Now().Add(59*time.Second).Unix() {
+		t.Errorf("expected the requeued item to wait for the Retry-After, not before %d", retry.NotBefore)
+	}
+	if cids := retry.Data["cids"].([]any); len(cids) != 1 || cids[0] != "slow" {
+		t.Errorf("expected the requeued item to hold slow, got %v", cids)
+	}
+}
+
+func TestProcessRetriesOnlyFailedCIDs(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer gateway.Close()
+
+	q := &memoryQueue{}
+	p := NewIPFSProcessor(q, emptyBackend{}, gateway.URL, time.Second, 1)
+	p.SetRetryPolicies(RetryPolicies{Transient: RetryPolicy{Attempts: 1}})
+
+	p.process(queue.NewQueueItem("item", map[string]any{"cids": []any{"broken", "slow"}}))
+
+	// the item is done, its throttled and failed CIDs are retried as separate items
+	_, done, nacked := q.counts()
+	if done != 1 || nacked != 0 {
+		t.Errorf("expected the item to be done, got %d done, %d nacked", done, nacked)
+	}
+	if len(q.items) != 2 {
+		t.Fatalf("expected 2 retry items, got %d", len(q.items))
+	}
+	retried := []string{}
+	for _, item := range q.items {
+		cids := item.Data["cids"].([]any)
+		if len(cids) != 1 {
+			t.Errorf("expected every retry item to hold one CID, got %v", cids)
+			continue
+		}
+		retried = append(retried, cids[0].(string))
+	}
+	sort.Strings(retried)
+	if strings.Join(retried, ",") != "broken,slow" {
+		t.Errorf("expected broken and slow to be retried once each, got %v", retried)
+	}
+}
+
+// recordingBackend has no records and remembers what was written to it.
+type recordingBackend struct {
+	emptyBackend
+	mu      sync.Mutex
+	created []any
+}
+
+func (b *recordingBackend) CreateBatch(items []any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.created = append(b.created, items...)
+	return nil
+}
+
+func TestWorkStoresPermanentFailures(t *testing.T) {
+	requests := 0
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/html":
+			fmt.Fprint(w, "<html></html>")
+		}
+	}))
+	defer gateway.Close()
+
+	b := &recordingBackend{}
+	p := NewIPFSProcessor(&memoryQueue{}, b, gateway.URL, time.Second, 1)
+	p.SetRetryPolicies(RetryPolicies{Permanent: RetryPolicy{Attempts: 2}})
+
+	result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": []any{"missing", "html"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Permanent) != 2 {
+		t.Errorf("expected 2 permanent failures, got %+v", result)
+	}
+
+	// the 404 is retried, the HTML isn't
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	if len(b.created) != 2 {
+		t.Fatalf("expected 2 negative records, got %d", len(b.created))
+	}
+	for _, created := range b.created {
+		record, err := json.Marshal(created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var metadata ipfs.Metadata
+		json.Unmarshal(record, &metadata)
+		if metadata.Error == "" {
+			t.Errorf("expected a negative record, got %+v", metadata)
+		}
+	}
+}
+
+// conflictingBackend reports every created record with a CID in taken as already existing.
+type conflictingBackend struct {
+	emptyBackend
+	taken map[string]bool
+}
+
+func (b conflictingBackend) CreateBatch(items []any) error {
+	ids := []string{}
+	for _, item := range items {
+		if metadata := item.(ipfs.Metadata); b.taken[metadata.CID] {
+			ids = append(ids, metadata.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return &backend.BatchConflictError{IDs: ids}
+}
+
+func TestWorkSkipsRecordsCreatedConcurrently(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "token"}`)
+	}))
+	defer gateway.Close()
+
+	b := conflictingBackend{taken: map[string]bool{"b": true}}
+	p := NewIPFSProcessor(&memoryQueue{}, b, gateway.URL, time.Second, 1)
+
+	result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": []any{"a", "b"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Fetched != 1 || result.Skipped != 1 || len(result.Failed) != 0 {
+		t.Errorf("expected 1 fetched and 1 skipped CID, got %+v", result)
+	}
+}
+
+// partialBackend fails to create the given CIDs, reporting them in a BatchFailedError.
+type partialBackend struct {
+	emptyBackend
+	failing map[string]bool
+}
+
+func (b partialBackend) CreateBatch(items []any) error {
+	ids := []string{}
+	for _, item := range items {
+		if metadata := item.(ipfs.Metadata); b.failing[metadata.CID] {
+			ids = append(ids, metadata.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return &backend.BatchFailedError{IDs: ids, Err: errors.New("secondary unavailable")}
+}
+
+func TestWorkFailsOnlyRecordsNotCreated(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "token"}`)
+	}))
+	defer gateway.Close()
+
+	b := partialBackend{failing: map[string]bool{"b": true}}
+	p := NewIPFSProcessor(&memoryQueue{}, b, gateway.URL, time.Second, 1)
+
+	result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": []any{"a", "b"}}))
+	if err == nil {
+		t.Fatal("expected the item to fail")
+	}
+	if result.Fetched != 1 || len(result.Failed) != 1 || result.Failed[0] != "b" {
+		t.Errorf("expected a to be fetched and b to fail, got %+v", result)
+	}
+}
+
+// unreadableBackend fails every read.
+type unreadableBackend struct {
+	emptyBackend
+}
+
+func (b unreadableBackend) ReadBatch(ids []string) ([]any, error) {
+	return nil, errors.New("backend unavailable")
+}
+
+func TestWorkFailsWhenExistingCIDsAreUnknown(t *testing.T) {
+	requests := 0
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"name": "token"}`)
+	}))
+	defer gateway.Close()
+
+	p := NewIPFSProcessor(&memoryQueue{}, unreadableBackend{}, gateway.URL, time.Second, 1)
+
+	result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": []any{"a", "b"}}))
+	if err == nil {
+		t.Fatal("expected the item to fail")
+	}
+	if requests != 0 || len(result.Failed) != 2 {
+		t.Errorf("expected 2 failed CIDs and no requests, got %d requests and %+v", requests, result)
+	}
+}
+
+// storedBackend holds a single record and remembers updates to it.
+type storedBackend struct {
+	emptyBackend
+	record  ipfs.Metadata
+	updated []ipfs.Metadata
+}
+
+func (b *storedBackend) ReadBatch(ids []string) ([]any, error) {
+	return []any{b.record}, nil
+}
+
+func (b *storedBackend) Update(item any) error {
+	b.updated = append(b.updated, item.(ipfs.Metadata))
+	return nil
+}
+
+func TestWorkBumpsFetchedAtOfFailedRefreshes(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer gateway.Close()
+
+	for _, previous := range []ipfs.Metadata{
+		{ID: ipfs.GenerateIDFromCID("cid"), CID: "cid", Name: "token", FetchedAt: 1, Version: 3},
+		{ID: ipfs.GenerateIDFromCID("cid"), CID: "cid", Error: "not found", FetchedAt: 1, Version: 3},
+	} {
+		b := &storedBackend{record: previous}
+		p := NewIPFSProcessor(&memoryQueue{}, b, gateway.URL, time.Second, 1)
+
+		result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": []any{"cid"}, "force": true}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Permanent) != 1 || len(b.updated) != 1 {
+			t.Fatalf("expected 1 permanent failure and 1 update, got %+v and %d updates", result, len(b.updated))
+		}
+
+		updated := b.updated[0]
+		if updated.FetchedAt <= previous.FetchedAt || updated.Version != previous.Version || updated.Name != previous.Name {
+			t.Errorf("expected only FetchedAt of %+v to change, got %+v", previous, updated)
+		}
+		if previous.Error != "" && !strings.Contains(updated.Error, "invalid JSON") {
+			t.Errorf("expected the negative record to get the new error, got %q", updated.Error)
+		}
+	}
+}
+
+func TestWorkOpensGatewayBreaker(t *testing.T) {
+	requests := 0
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer gateway.Close()
+
+	q := &memoryQueue{}
+	p := NewIPFSProcessor(q, emptyBackend{}, gateway.URL, time.Second, 1)
+	p.SetRetryPolicies(RetryPolicies{Transient: RetryPolicy{Attempts: 1}})
+	p.SetBreakerPolicy(2, time.Minute)
+
+	result, err := p.Work(queue.NewQueueItem("item", map[string]any{"cids": []any{"a", "b", "c", "d"}}))
+	if err == nil {
+		t.Fatal("expected the item to fail")
+	}
+
+	// two requests fail, then the open breaker keeps the other CIDs from reaching the gateway
+	if requests != 2 || len(result.Failed) != 2 || len(result.Requeued) != 2 {
+		t.Errorf("expected 2 requests, 2 failed and 2 requeued CIDs, got %d requests and %+v", requests, result)
+	}
+	if state := p.BreakerStates()["gateway "+gateway.URL]; state != Open {
+		t.Errorf("expected the gateway breaker to be open, got %s", state)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected /healthz to answer 503, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,175 @@
+package processor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ThrottledError is returned by FetchCID when a gateway throttles us, or would have to be waited on for too long.
+// The CID should be tried again after RetryAfter.
+type ThrottledError struct {
+	Gateway    string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("gateway %s is rate limited, retry after %s", e.Gateway, e.RetryAfter)
+	}
+	return fmt.Sprintf("gateway %s throttled us with status code %d, retry after %s", e.Gateway, e.StatusCode, e.RetryAfter)
+}
+
+// defaultRetryAfter is how long a gateway is left alone after a 429 or 503 without a Retry-After header.
+const defaultRetryAfter = 30 * time.Second
+
+// retryAfter returns the delay the response asks for in its Retry-After header, in seconds or as an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return defaultRetryAfter
+	}
+
+	seconds, err := strconv.Atoi(header)
+	if err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	at, err := http.ParseTime(header)
+	if err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+
+	return defaultRetryAfter
+}
+
+// RateLimiter keeps a token bucket per gateway that is shared by all workers of a processor.
+// A gateway that throttles us gets its rate halved and is left alone for as long as it asks for,
+// every successful request after that raises the rate again until it's back at the configured rate.
+type RateLimiter struct {
+	// rate is the configured requests per second, zero means no limit besides what gateways ask for
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket is the state of one gateway.
+type bucket struct {
+	tokens float64
+	rate   float64
+	last   time.Time
+	// blockedUntil is when a gateway that throttled us may be asked again
+	blockedUntil time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing rate requests per second to every gateway, with bursts of up to burst
+// requests. A rate of zero or less only honors the gateways' Retry-After.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+	}
+}
+
+// get returns the bucket of the gateway, l.mu must be held.
+func (l *RateLimiter) get(gateway string, now time.Time) *bucket {
+	b, ok := l.buckets[gateway]
+	if !ok {
+		b = &bucket{tokens: l.burst, rate: l.rate, last: now}
+		l.buckets[gateway] = b
+	}
+
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+	}
+	b.last = now
+
+	return b
+}
+
+// Take waits until the gateway may be sent a request and reserves it.
+// If that would take longer than maxWait it doesn't wait and returns how long it would have taken and false.
+func (l *RateLimiter) Take(gateway string, maxWait time.Duration) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	b := l.get(gateway, now)
+
+	var wait time.Duration
+	if now.Before(b.blockedUntil) {
+		wait = b.blockedUntil.Sub(now)
+	}
+	if b.rate > 0 && b.tokens < 1 {
+		tokenWait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		if tokenWait > wait {
+			wait = tokenWait
+		}
+	}
+	if wait > maxWait {
+		l.mu.Unlock()
+		return wait, false
+	}
+
+	// the token may be taken ahead of time, the next caller waits for the bucket to refill past it
+	if b.rate > 0 {
+		b.tokens--
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return wait, true
+}
+
+// Throttled slows requests to the gateway down after it answered with 429 or 503,
+// no request is sent to it before retryAfter has passed.
+func (l *RateLimiter) Throttled(gateway string, retryAfter time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.get(gateway, now)
+	if until := now.Add(retryAfter); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+	if b.rate > 0 {
+		b.rate /= 2
+		if b.rate < l.rate/32 {
+			b.rate = l.rate / 32
+		}
+		if b.tokens > 0 {
+			b.tokens = 0
+		}
+	}
+}
+
+// Succeeded raises the rate of a gateway that was throttled before back towards the configured rate.
+func (l *RateLimiter) Succeeded(gateway string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[gateway]
+	if !ok || b.rate >= l.rate {
+		return
+	}
+
+	b.rate += l.rate / 20
+	if b.rate > l.rate {
+		b.rate = l.rate
+	}
+}
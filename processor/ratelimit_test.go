@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	l := NewRateLimiter(10, 2)
+
+	// the burst is served right away, the next request waits for a token
+	for i := 0; i < 2; i++ {
+		wait, ok := l.Take("gw", time.Second)
+		if !ok || wait != 0 {
+			t.Fatalf("expected request %d to be served right away, waited %s", i, wait)
+		}
+	}
+	wait, ok := l.Take("gw", time.Second)
+	if !ok || wait <= 0 {
+		t.Errorf("expected to wait for a token, waited %s", wait)
+	}
+
+	// other gateways have their own bucket
+	wait, ok = l.Take("other", time.Second)
+	if !ok || wait != 0 {
+		t.Errorf("expected another gateway to be served right away, waited %s", wait)
+	}
+}
+
+func TestRateLimiterThrottled(t *testing.T) {
+	l := NewRateLimiter(0, 0)
+
+	l.Throttled("gw", time.Minute)
+	wait, ok := l.Take("gw", time.Second)
+	if ok {
+		t.Fatal("expected a throttled gateway not to be served")
+	}
+	if wait < 59*time.Second {
+		t.Errorf("expected to be told to wait about a minute, got %s", wait)
+	}
+
+	l = NewRateLimiter(10, 1)
+	l.Throttled("gw", 0)
+	if rate := l.buckets["gw"].rate; rate != 5 {
+		t.Errorf("expected the rate to be halved to 5, got %g", rate)
+	}
+	l.Succeeded("gw")
+	if rate := l.buckets["gw"].rate; rate != 5.5 {
+		t.Errorf("expected the rate to be raised to 5.5, got %g", rate)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for header, want := range map[string]time.Duration{
+		"":                              defaultRetryAfter,
+		"120":                           2 * time.Minute,
+		"Sun, 01 Jan 2023 00:00:30 GMT": 30 * time.Second,
+		"soon":                          defaultRetryAfter,
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if header != "" {
+			resp.Header.Set("Retry-After", header)
+		}
+		if got := retryAfter(resp, now); got != want {
+			t.Errorf("Retry-After %q: expected %s, got %s", header, want, got)
+		}
+	}
+}
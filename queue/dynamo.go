@@ -1,7 +1,10 @@
 package queue
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -11,12 +14,25 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultIdempotencyWindow is how long an idempotency key blocks duplicate items by default.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// QueueItemIDIndex is the global secondary index Contains queries, keyed on the QueueItemID attribute
+// of queue rows. It only needs to project the keys.
+const QueueItemIDIndex = "QueueItemID-index"
+
 // DynamoDBQueue represents a queue backed by a DynamoDB table.
+//
+// Claimed items carry the key of their row as Receipt, so Done and Nack address the row directly.
+// Contains looks items up by ID through the QueueItemIDIndex, which is eventually consistent,
+// so an item added a moment ago may not be found yet.
 type DynamoDBQueue struct {
 	TableName string
 	QueueName string
-	svc       *dynamodb.DynamoDB
-	logger    *logrus.Entry
+	// IdempotencyWindow is how long an item's IdempotencyKey blocks duplicates.
+	IdempotencyWindow time.Duration
+	svc               *dynamodb.DynamoDB
+	logger            *logrus.Entry
 }
 
 // NewDynamoDBQueue creates a new DynamoDBQueue instance.
@@ -31,10 +47,11 @@ func NewDynamoDBQueue(tableName, queueName string, svc *dynamodb.DynamoDB) (Queu
 	}
 
 	return &DynamoDBQueue{
-		TableName: tableName,
-		QueueName: queueName,
-		svc:       svc,
-		logger:    logrus.WithField("component", "DynamoDBQueue"),
+		TableName:         tableName,
+		QueueName:         queueName,
+		IdempotencyWindow: DefaultIdempotencyWindow,
+		svc:               svc,
+		logger:            logrus.WithField("component", "DynamoDBQueue"),
 	}, nil
 }
 
@@ -54,6 +71,10 @@ func (q *DynamoDBQueue) AddItem(queueItem QueueItem) error {
 		return fmt.Errorf("failed to create new DDBQueueItem")
 	}
 
+	if queueItem.IdempotencyKey != "" {
+		return q.addIdempotentItem(ddbitem)
+	}
+
 	// Put the item in the DynamoDB table
 	_, err := q.svc.PutItem(&dynamodb.PutItemInput{
 		TableName: aws.String(q.TableName),
@@ -69,19 +90,96 @@ func (q *DynamoDBQueue) AddItem(queueItem QueueItem) error {
 	return nil
 }
 
+// GenerateQueueItemKey returns the QueueItemID attribute of the rows of items with the given ID.
+func (q *DynamoDBQueue) GenerateQueueItemKey(id string) string {
+	return fmt.Sprintf("%s/%s", q.QueueName, id)
+}
+
+// GenerateIdempotencyID returns the ID of the marker row that guards an idempotency key.
+func (q *DynamoDBQueue) GenerateIdempotencyID(key string) string {
+	return fmt.Sprintf("idem-%s-%s", q.QueueName, key)
+}
+
+// addIdempotentItem writes the item together with a marker row for its idempotency key in one transaction.
+// The marker is only written if no live marker exists, so duplicates inside the window are dropped.
+func (q *DynamoDBQueue) addIdempotentItem(ddbitem *DDBQueueItem) error {
+	now := time.Now()
+	markerID := q.GenerateIdempotencyID(ddbitem.Data.IdempotencyKey)
+
+	// ExpiresAt can double as the table's TTL attribute to clean up old markers
+	condExpr, err := expression.NewBuilder().
+		WithCondition(expression.Or(
+			expression.AttributeNotExists(expression.Name("ID")),
+			expression.LessThan(expression.Name("ExpiresAt"), expression.Value(now.Unix())),
+		)).
+		Build()
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to build DynamoDB expression")
+		return err
+	}
+
+	_, err = q.svc.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(q.TableName),
+					Item: map[string]*dynamodb.AttributeValue{
+						"ID":        {S: aws.String(markerID)},
+						"ItemID":    {S: aws.String(ddbitem.ID)},
+						"ExpiresAt": {N: aws.String(strconv.FormatInt(now.Add(q.IdempotencyWindow).Unix(), 10))},
+					},
+					ConditionExpression:       condExpr.Condition(),
+					ExpressionAttributeNames:  condExpr.Names(),
+					ExpressionAttributeValues: condExpr.Values(),
+				},
+			},
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(q.TableName),
+					Item:      ddbitem.AV(),
+				},
+			},
+		},
+	})
+
+	var canceled *dynamodb.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		q.logger.WithField("key", ddbitem.Data.IdempotencyKey).Infof("Duplicate item dropped from queue: %s", q.QueueName)
+		return ErrDuplicate
+	}
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to put item in DynamoDB table")
+		return err
+	}
+
+	q.logger.WithField("ID", ddbitem.ID).Infof("Item added to queue: %s", q.QueueName)
+	return nil
+}
+
 // Pop locks and returns the next item from the queue.
 func (q *DynamoDBQueue) GetNextItem() (QueueItem, error) {
+	return firstItem(q.GetNextItems(1))
+}
+
+// GetNextItems scans for up to max available items and locks them with parallel conditional updates.
+// Items another worker locked first are left out, so fewer than max items may be returned.
+func (q *DynamoDBQueue) GetNextItems(max int) ([]QueueItem, error) {
+	if max <= 0 {
+		return []QueueItem{}, nil
+	}
 
 	expr, err := expression.NewBuilder().
 		WithFilter(expression.And(
 			IsUnlockedCondition(),
+			IsReadyCondition(),
 			expression.BeginsWith(expression.Name("ID"), fmt.Sprintf("queue-%s-", q.QueueName)),
 		)).
 		Build()
 
 	if err != nil {
 		q.logger.WithError(err).Error("Failed to build DynamoDB expression")
-		return QueueItem{}, err
+		return nil, err
 	}
 
 	// Define the input parameters for the Scan operation
@@ -92,80 +190,173 @@ func (q *DynamoDBQueue) GetNextItem() (QueueItem, error) {
 		FilterExpression:          expr.Filter(),
 	}
 
-	// Execute the Scan operation
-	result, err := q.svc.Scan(input)
+	// Execute the Scan operation until enough candidates are found
+	candidates := []map[string]*dynamodb.AttributeValue{}
+	err = q.svc.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		candidates = append(candidates, page.Items...)
+		return len(candidates) < max
+	})
 	if err != nil {
 		q.logger.WithError(err).Error("Failed to scan DynamoDB table")
-		return QueueItem{}, err
+		return nil, err
 	}
 	// Check if any items were returned by the Scan operation
-	if len(result.Items) == 0 {
+	if len(candidates) == 0 {
 		q.logger.Infof("No items available in table: %s for queue: %s", q.TableName, q.QueueName)
-		return QueueItem{}, fmt.Errorf("no items available in queue")
+		return []QueueItem{}, nil
+	}
+	if len(candidates) > max {
+		candidates = candidates[:max]
 	}
-	q.logger.Infof("Found an item in the queue, unmarshalling.")
+	q.logger.Infof("Found %d items in the queue, unmarshalling.", len(candidates))
 
 	// Unmarshal and Lock
-	item := NewDDBQueueItemWithOptions(result.Items[0], q)
-	err = item.Lock()
-	if err != nil {
-		q.logger.WithError(err).Warn("something may have beat us to the lock. Move on!")
-		return QueueItem{}, fmt.Errorf("something may have beat us to the lock. Move on!")
+	locked := make([]*DDBQueueItem, len(candidates))
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		item := NewDDBQueueItemWithOptions(candidate, q)
+		if item == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, item *DDBQueueItem) {
+			defer wg.Done()
+			err := item.Lock()
+			if err != nil {
+				q.logger.WithError(err).Warn("something may have beat us to the lock. Move on!")
+				return
+			}
+			locked[i] = item
+		}(i, item)
+	}
+	wg.Wait()
+
+	items := make([]QueueItem, 0, len(locked))
+	for _, item := range locked {
+		if item != nil {
+			item.Data.Receipt = item.ID
+			items = append(items, item.Data)
+		}
 	}
 
-	return item.Data, nil
+	return items, nil
 }
 
-// Done removes the specified item from DynamoDB.
+// Done removes the specified item from DynamoDB, by its Receipt if it has one.
 func (q *DynamoDBQueue) Done(item QueueItem) error {
-	// Create a new expression to delete the item from DynamoDB
-	deleteExpr, err := expression.NewBuilder().
-		WithFilter(expression.And(
-			expression.Equal(expression.Name("Data.id"), expression.Value(item.ID)),
-			expression.BeginsWith(expression.Name("ID"), fmt.Sprintf("queue-%s-", q.QueueName)))).Build()
+	key, err := q.rowKey(item)
 	if err != nil {
-		q.logger.WithError(err).Error("Failed to build delete condition expression")
 		return err
 	}
-
-	// Define the input parameters for the Scan operation
-	scanInput := &dynamodb.ScanInput{
-		TableName:                 aws.String(q.TableName),
-		ExpressionAttributeNames:  deleteExpr.Names(),
-		ExpressionAttributeValues: deleteExpr.Values(),
-		FilterExpression:          deleteExpr.Filter(),
+	if key == nil {
+		q.logger.WithField("ID", item.ID).Info("No items available in table to remove")
+		return nil
 	}
 
-	// Execute the Scan operation
-	result, err := q.svc.Scan(scanInput)
+	_, err = q.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:       map[string]*dynamodb.AttributeValue{"ID": key},
+		TableName: aws.String(q.TableName),
+	})
 	if err != nil {
-		q.logger.WithError(err).Error("Failed to scan DynamoDB table")
+		q.logger.WithError(err).WithField("ID", aws.StringValue(key.S)).Error("Failed to delete item from DynamoDB")
 		return err
 	}
 
-	// Check if any items were returned by the Scan operation
-	if len(result.Items) == 0 {
-		q.logger.Info("No items available in table to remove")
-		return nil
+	q.logger.WithField("ID", aws.StringValue(key.S)).Info("Item deleted from DynamoDB")
+
+	return nil
+}
+
+// Contains reports whether an item with the given ID is still in the queue.
+func (q *DynamoDBQueue) Contains(id string) (bool, error) {
+	item, err := q.find(id)
+	if err != nil {
+		return false, err
 	}
 
-	q.logger.WithField("ID", result.Items[0]["ID"]).Info("Item found in DynamoDB")
+	return item != nil, nil
+}
 
-	// Delete the item from DynamoDB
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"ID": result.Items[0]["ID"],
-		},
-		TableName: aws.String(q.TableName),
+// Nack unlocks the specified item, by its Receipt if it has one, so it is handed out again once delay has passed.
+func (q *DynamoDBQueue) Nack(item QueueItem, delay time.Duration) error {
+	key, err := q.rowKey(item)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		q.logger.WithField("ID", item.ID).Info("No item available in table to nack")
+		return nil
 	}
 
-	_, err = q.svc.DeleteItem(input)
+	updateExpr, err := expression.NewBuilder().
+		WithCondition(expression.Equal(expression.Name("Locked"), expression.Value(true))).
+		WithUpdate(
+			expression.Set(expression.Name("Locked"), expression.Value(false)).
+				Set(expression.Name("NotBefore"), expression.Value(time.Now().Add(delay).Unix())).
+				Set(expression.Name("LockTime"), expression.Value(0)),
+		).
+		Build()
 	if err != nil {
-		q.logger.WithError(err).WithField("item", result.Items[0]).Error("Failed to delete item from DynamoDB")
+		q.logger.WithError(err).Error("Failed to build DynamoDB expression")
 		return err
 	}
 
-	q.logger.WithField("ID", *result.Items[0]["ID"].S).Info("Item deleted from DynamoDB")
+	_, err = q.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(q.TableName),
+		Key:                       map[string]*dynamodb.AttributeValue{"ID": key},
+		UpdateExpression:          updateExpr.Update(),
+		ConditionExpression:       updateExpr.Condition(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to unlock item in DynamoDB table")
+		return err
+	}
 
+	q.logger.WithField("ID", item.ID).Infof("Item released back to queue: %s", q.QueueName)
 	return nil
 }
+
+// rowKey returns the key of the item's row, its Receipt or else the row found by its ID, or nil if it is not in the queue.
+func (q *DynamoDBQueue) rowKey(item QueueItem) (*dynamodb.AttributeValue, error) {
+	if item.Receipt != "" {
+		return &dynamodb.AttributeValue{S: aws.String(item.Receipt)}, nil
+	}
+
+	found, err := q.find(item.ID)
+	if err != nil || found == nil {
+		return nil, err
+	}
+	return found["ID"], nil
+}
+
+// find returns the keys of a row of an item with the given ID, or nil if it is not in the queue.
+func (q *DynamoDBQueue) find(id string) (map[string]*dynamodb.AttributeValue, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key("QueueItemID").Equal(expression.Value(q.GenerateQueueItemKey(id)))).
+		Build()
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to build DynamoDB expression")
+		return nil, err
+	}
+
+	result, err := q.svc.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String(q.TableName),
+		IndexName:                 aws.String(QueueItemIDIndex),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Limit:                     aws.Int64(1),
+	})
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to query DynamoDB table")
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, nil
+	}
+
+	return result.Items[0], nil
+}
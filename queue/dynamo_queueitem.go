@@ -16,8 +16,10 @@ type DDBQueueItem struct {
 	Data     QueueItem
 	Locked   bool
 	LockTime int64
-	av       *dynamodb.AttributeValue
-	queue    *DynamoDBQueue
+	// NotBefore is the unix time before which the item is not handed out.
+	NotBefore int64
+	av        *dynamodb.AttributeValue
+	queue     *DynamoDBQueue
 }
 
 // NewDDBQueueItem creates a new DDBQueueItem instance with the specified ID, data, locked status, lock time, DynamoDB service, and logger.
@@ -29,20 +31,34 @@ func NewDDBQueueItemWithOptions(item map[string]*dynamodb.AttributeValue, q *Dyn
 		q.logger.WithError(err).Error("Failed to unmarshal QueueItem from DynamoDB attribute value")
 		return nil
 	}
-	// Convert the LockTime attribute to an int64 value
-	lockTime, err := strconv.ParseInt(*item["LockTime"].N, 10, 64)
-	if err != nil {
-		q.logger.WithError(err).Error("Failed to parse LockTime attribute")
-		return nil
+	// Convert the LockTime attribute to an int64 value, rows unlocked by older versions don't have it
+	var lockTime int64
+	if lt, ok := item["LockTime"]; ok && lt.N != nil {
+		lockTime, err = strconv.ParseInt(*lt.N, 10, 64)
+		if err != nil {
+			q.logger.WithError(err).Error("Failed to parse LockTime attribute")
+			return nil
+		}
+	}
+
+	// NotBefore is optional, items written before scheduling support don't have it
+	var notBefore int64
+	if nb, ok := item["NotBefore"]; ok && nb.N != nil {
+		notBefore, err = strconv.ParseInt(*nb.N, 10, 64)
+		if err != nil {
+			q.logger.WithError(err).Error("Failed to parse NotBefore attribute")
+			return nil
+		}
 	}
 
 	return &DDBQueueItem{
-		ID:       *item["ID"].S,
-		Data:     data,
-		Locked:   *item["Locked"].BOOL,
-		LockTime: lockTime,
-		av:       item["Data"],
-		queue:    q,
+		ID:        *item["ID"].S,
+		Data:      data,
+		Locked:    *item["Locked"].BOOL,
+		LockTime:  lockTime,
+		NotBefore: notBefore,
+		av:        item["Data"],
+		queue:     q,
 	}
 }
 
@@ -55,25 +71,34 @@ func NewDDBQueueItem(data QueueItem, q *DynamoDBQueue) *DDBQueueItem {
 		return nil
 	}
 	return &DDBQueueItem{
-		ID:       q.GenerateQueueItemID(),
-		Data:     data,
-		Locked:   false,
-		LockTime: 0,
-		av:       av,
-		queue:    q,
+		ID:        q.GenerateQueueItemID(),
+		Data:      data,
+		Locked:    false,
+		LockTime:  0,
+		NotBefore: data.NotBefore,
+		av:        av,
+		queue:     q,
 	}
 }
 
 // AV returns the item as a map of DynamoDB attribute values.
 func (item *DDBQueueItem) AV() map[string]*dynamodb.AttributeValue {
-
-	return map[string]*dynamodb.AttributeValue{
+	av := map[string]*dynamodb.AttributeValue{
 		"ID":       {S: aws.String(item.ID)},
 		"Data":     item.av,
 		"Locked":   {BOOL: aws.Bool(item.Locked)},
 		"LockTime": {N: aws.String(strconv.FormatInt(item.LockTime, 10))},
+		// QueueItemID keys the QueueItemIDIndex that Contains queries
+		"QueueItemID": {S: aws.String(item.queue.GenerateQueueItemKey(item.Data.ID))},
+	}
+
+	// NotBefore lives at the top level so GetNextItem can filter on it
+	if item.NotBefore > 0 {
+		av["NotBefore"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(item.NotBefore, 10))}
 	}
 
+	return av
+
 }
 
 // Lock locks the item in the queue.
@@ -115,7 +140,7 @@ func (item *DDBQueueItem) Unlock() error {
 	updateExpr, err := expression.NewBuilder().
 		WithCondition(expression.Equal(expression.Name("Locked"), expression.Value(true))).
 		WithUpdate(
-			expression.Set(expression.Name("Locked"), expression.Value(false)).Remove(expression.Name("LockTime")),
+			expression.Set(expression.Name("Locked"), expression.Value(false)).Set(expression.Name("LockTime"), expression.Value(0)),
 		).
 		Build()
 	if err != nil {
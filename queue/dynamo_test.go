@@ -1,59 +1,149 @@
 package queue
 
-// func TestDynamoDBQueue_Push(t *testing.T) {
-// 	// Create a new mock DynamoDB client
-// 	// ctrl := gomock.NewController(t)
-// 	// defer ctrl.Finish()
-// 	// mockSvc := mock_dynamodb.NewMockDynamoDBAPI(ctrl)
-
-// 	sess, _ := session.NewSessionWithOptions(session.Options{
-// 		Config: aws.Config{
-// 			Region:   aws.String("us-east-1"),
-// 			Endpoint: aws.String("http://localhost:8000"),
-// 		},
-// 	})
-
-// 	svc := dynamodb.New(sess)
-
-// 	logger := logrus.New()
-// 	// Create a new queue with the mock client
-// 	q, _ := NewDynamoDBQueue("ipfs-dev", "test-table", svc, logger)
-
-// 	err := q.Push(map[string]any{
-// 		"test-key": "test-value",
-// 	})
-// 	if err != nil {
-// 		t.Error(err)
-// 	}
-
-// }
-
-// func TestDynamoDBQueue_Pop(t *testing.T) {
-// 	// Create a new mock DynamoDB client
-// 	// ctrl := gomock.NewController(t)
-// 	// defer ctrl.Finish()
-// 	// mockSvc := mock_dynamodb.NewMockDynamoDBAPI(ctrl)
-
-// 	sess, _ := session.NewSessionWithOptions(session.Options{
-// 		Config: aws.Config{
-// 			Region:   aws.String("us-east-1"),
-// 			Endpoint: aws.String("http://localhost:8000"),
-// 		},
-// 	})
-
-// 	svc := dynamodb.New(sess)
-
-// 	logger := logrus.New()
-// 	// Create a new queue with the mock client
-// 	q, _ := NewDynamoDBQueue("ipfs-dev", "test-table", svc, logger)
-
-// 	item, err := q.Pop()
-// 	if err != nil {
-// 		t.Error(err)
-// 	}
-
-// 	if item != nil {
-// 		fmt.Println(*item)
-// 	}
-
-// }
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestDynamoDBQueue creates a queue in a new table on the DynamoDB compatible server in
+// IPFS_DYNAMODB_TEST_ENDPOINT, e.g. DynamoDB Local.
+func newTestDynamoDBQueue(t *testing.T) *DynamoDBQueue {
+	endpoint := os.Getenv("IPFS_DYNAMODB_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("IPFS_DYNAMODB_TEST_ENDPOINT not set")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := dynamodb.New(sess)
+
+	tableName := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	_, err = svc.CreateTable(&dynamodb.CreateTableInput{
+		TableName:   aws.String(tableName),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("ID"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("QueueItemID"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("ID"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{{
+			IndexName: aws.String(QueueItemIDIndex),
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String("QueueItemID"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			},
+			Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeKeysOnly)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(tableName)}) })
+
+	q, err := NewDynamoDBQueue(tableName, "test", svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q.(*DynamoDBQueue)
+}
+
+func TestNewDDBQueueItemWithoutLockTime(t *testing.T) {
+	q := &DynamoDBQueue{QueueName: "test", logger: logrus.WithField("component", "DynamoDBQueue")}
+	av := NewDDBQueueItem(NewQueueItem("item", map[string]any{}), q).AV()
+	delete(av, "LockTime")
+
+	item := NewDDBQueueItemWithOptions(av, q)
+	if item == nil {
+		t.Fatal("expected a row without LockTime to be read")
+	}
+	if item.LockTime != 0 || item.Data.ID != "item" {
+		t.Errorf("expected item with LockTime 0, got %+v", item)
+	}
+}
+
+func TestDynamoDBQueueNack(t *testing.T) {
+	q := newTestDynamoDBQueue(t)
+
+	err := q.AddItem(NewQueueItem("item", map[string]any{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Nack(item, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a nacked item is handed out again
+	again, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != "item" || again.Receipt != item.Receipt {
+		t.Errorf("expected the nacked item back, got %+v", again)
+	}
+
+	err = q.Done(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := q.GetNextItems(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected an empty queue, got %+v", items)
+	}
+}
+
+func TestDynamoDBQueueNotBefore(t *testing.T) {
+	q := newTestDynamoDBQueue(t)
+
+	notBefore := time.Now().Add(2 * time.Second)
+	err := q.AddItem(NewScheduledQueueItem("scheduled", map[string]any{}, notBefore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.AddItem(NewQueueItem("ready", map[string]any{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// items scheduled in the future are skipped
+	items, err := q.GetNextItems(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != "ready" {
+		t.Fatalf("expected only the ready item, got %+v", items)
+	}
+
+	// and handed out once their NotBefore time has passed, NotBefore has a resolution of a second
+	time.Sleep(time.Until(notBefore.Truncate(time.Second).Add(time.Second)))
+	items, err = q.GetNextItems(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != "scheduled" {
+		t.Errorf("expected the scheduled item, got %+v", items)
+	}
+}
@@ -13,9 +13,17 @@ func IsUnlockedCondition() expression.ConditionBuilder {
 		expression.And(
 			expression.Equal(expression.Name("Locked"), expression.Value(true)),
 			expression.Or(
-				expression.LessThan(expression.Name("LockTime"), expression.Value(time.Now().Add(-time.Hour).UnixNano())),
+				expression.LessThan(expression.Name("LockTime"), expression.Value(time.Now().Add(-LockTimeout).UnixNano())),
 				expression.AttributeNotExists(expression.Name("LockTime")),
 			),
 		),
 	)
 }
+
+// IsReadyCondition is the condition for queue items whose NotBefore time has passed.
+func IsReadyCondition() expression.ConditionBuilder {
+	return expression.Or(
+		expression.AttributeNotExists(expression.Name("NotBefore")),
+		expression.LessThanEqual(expression.Name("NotBefore"), expression.Value(time.Now().Unix())),
+	)
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/sirupsen/logrus"
+)
+
+// FreshCIDQueue wraps a Queue and drops CIDs from new items when the backend already has
+// Metadata for them that was fetched within freshFor.
+type FreshCIDQueue struct {
+	Queue
+	store    *backend.Store[ipfs.Metadata]
+	freshFor time.Duration
+	logger   *logrus.Entry
+}
+
+// NewFreshCIDQueue creates a new FreshCIDQueue around q.
+func NewFreshCIDQueue(q Queue, b backend.Backend, freshFor time.Duration) Queue {
+	return &FreshCIDQueue{
+		Queue:    q,
+		store:    backend.NewStore[ipfs.Metadata](b),
+		freshFor: freshFor,
+		logger:   logrus.WithField("component", "FreshCIDQueue"),
+	}
+}
+
+// AddItem removes fresh CIDs from the item's cids and adds it to the wrapped queue.
+// Items left without any CIDs are not enqueued at all.
+func (q *FreshCIDQueue) AddItem(item QueueItem) error {
+	cidsAny, ok := item.Data["cids"]
+	if !ok {
+		return q.Queue.AddItem(item)
+	}
+
+	cidsAnySlice, ok := cidsAny.([]any)
+	if !ok {
+		return q.Queue.AddItem(item)
+	}
+
+	// forced fetches ask for a refresh, fresh or not
+	if force, _ := item.Data["force"].(bool); force {
+		return q.Queue.AddItem(item)
+	}
+
+	fetchedAt := q.fetchedAt(cidsAnySlice)
+	freshAfter := time.Now().Add(-q.freshFor).Unix()
+	stale := []any{}
+	for _, cidAny := range cidsAnySlice {
+		cid, ok := cidAny.(string)
+		if !ok || fetchedAt[ipfs.GenerateIDFromCID(cid)] <= freshAfter {
+			stale = append(stale, cidAny)
+		}
+	}
+
+	if len(stale) == 0 {
+		q.logger.WithField("ID", item.ID).Info("All CIDs are fresh, item not enqueued")
+		return nil
+	}
+
+	if skipped := len(cidsAnySlice) - len(stale); skipped > 0 {
+		q.logger.WithField("ID", item.ID).Infof("Skipped %d fresh CIDs", skipped)
+	}
+
+	// copy the data so the caller's map is left alone
+	data := make(map[string]any, len(item.Data))
+	for k, v := range item.Data {
+		data[k] = v
+	}
+	data["cids"] = stale
+	item.Data = data
+
+	return q.Queue.AddItem(item)
+}
+
+// fetchedAt looks the CIDs up in one batch read and returns when each metadata record
+// found was fetched, by record ID. CIDs count as missing if the read fails.
+func (q *FreshCIDQueue) fetchedAt(cids []any) map[string]int64 {
+	ids := []string{}
+	for _, cidAny := range cids {
+		if cid, ok := cidAny.(string); ok {
+			ids = append(ids, ipfs.GenerateIDFromCID(cid))
+		}
+	}
+
+	fetchedAt := map[string]int64{}
+	if len(ids) == 0 {
+		return fetchedAt
+	}
+
+	records, err := q.store.GetBatch(ids)
+	if err != nil {
+		q.logger.WithError(err).Warn("Failed to read metadata records")
+		return fetchedAt
+	}
+	for _, metadata := range records {
+		fetchedAt[metadata.ID] = metadata.FetchedAt
+	}
+
+	return fetchedAt
+}
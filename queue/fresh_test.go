@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/redis/go-redis/v9"
+)
+
+// metadataBackend serves metadata records from a map and counts batch reads.
+type metadataBackend struct {
+	backend.Backend
+	records map[string]ipfs.Metadata
+	reads   int
+}
+
+func (b *metadataBackend) ReadBatch(ids []string) ([]any, error) {
+	b.reads++
+	items := []any{}
+	for _, id := range ids {
+		if record, ok := b.records[id]; ok {
+			items = append(items, record)
+		}
+	}
+	return items, nil
+}
+
+func TestFreshCIDQueue(t *testing.T) {
+	s := miniredis.RunT(t)
+	inner, err := NewRedisQueue(redis.NewClient(&redis.Options{Addr: s.Addr()}), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &metadataBackend{records: map[string]ipfs.Metadata{}}
+	for cid, fetchedAt := range map[string]time.Time{
+		"fresh": time.Now(),
+		"stale": time.Now().Add(-2 * time.Hour),
+	} {
+		id := ipfs.GenerateIDFromCID(cid)
+		b.records[id] = ipfs.Metadata{ID: id, CID: cid, FetchedAt: fetchedAt.Unix()}
+	}
+	q := NewFreshCIDQueue(inner, b, time.Hour)
+
+	// fresh CIDs are dropped in one batch read, stale and missing ones are kept
+	cids := []any{"fresh", "stale", "missing"}
+	err = q.AddItem(NewQueueItem("mixed", map[string]any{"cids": cids}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.reads != 1 {
+		t.Errorf("expected one batch read, got %d", b.reads)
+	}
+	if len(cids) != 3 {
+		t.Errorf("expected the caller's CIDs to be left alone, got %v", cids)
+	}
+
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := item.Data["cids"].([]any); len(got) != 2 || got[0] != "stale" || got[1] != "missing" {
+		t.Errorf("expected the stale and missing CIDs, got %v", got)
+	}
+	err = q.Done(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// items with only fresh CIDs aren't enqueued
+	err = q.AddItem(NewQueueItem("fresh", map[string]any{"cids": []any{"fresh"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contains, err := q.Contains("fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains {
+		t.Error("expected an item with only fresh CIDs to be dropped")
+	}
+
+	// forced fetches are enqueued as they are
+	err = q.AddItem(NewQueueItem("forced", map[string]any{"cids": []any{"fresh"}, "force": true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err = q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := item.Data["cids"].([]any); item.ID != "forced" || len(got) != 1 || got[0] != "fresh" {
+		t.Errorf("expected the forced fetch of the fresh CID, got %+v", item)
+	}
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Notifier signals consumers that items were added to a queue, so they don't have to wait for their next poll.
+// Notifications are hints: several may be merged into one and a consumer still has to call GetNextItem.
+type Notifier interface {
+	Notifications() <-chan struct{}
+}
+
+// NotifyingQueue wraps a Queue and notifies consumers in the same process whenever an item is added or released.
+type NotifyingQueue struct {
+	Queue
+	ch chan struct{}
+}
+
+// NewNotifyingQueue returns q with in-process notifications.
+func NewNotifyingQueue(q Queue) *NotifyingQueue {
+	return &NotifyingQueue{
+		Queue: q,
+		ch:    make(chan struct{}, 1),
+	}
+}
+
+// Notifications implements Notifier.
+func (q *NotifyingQueue) Notifications() <-chan struct{} {
+	return q.ch
+}
+
+// AddItem adds the item to the wrapped queue and notifies consumers.
+func (q *NotifyingQueue) AddItem(item QueueItem) error {
+	err := q.Queue.AddItem(item)
+	if err == nil {
+		q.notify()
+	}
+	return err
+}
+
+// Nack releases the item to the wrapped queue and notifies consumers if it is available again immediately.
+func (q *NotifyingQueue) Nack(item QueueItem, delay time.Duration) error {
+	err := q.Queue.Nack(item, delay)
+	if err == nil && delay <= 0 {
+		q.notify()
+	}
+	return err
+}
+
+// notify doesn't block, a pending notification already covers this one.
+func (q *NotifyingQueue) notify() {
+	select {
+	case q.ch <- struct{}{}:
+	default:
+	}
+}
+
+// postgresNotifyChannel is the channel PostgresQueue sends a NOTIFY on with the queue name as payload.
+const postgresNotifyChannel = "queue_items"
+
+// PostgresNotifier listens for the notifications PostgresQueue sends when items are added,
+// so workers in every process are woken up.
+type PostgresNotifier struct {
+	QueueName string
+	listener  *pq.Listener
+	ch        chan struct{}
+	logger    *logrus.Entry
+}
+
+// NewPostgresNotifier listens on the database at dsn for items added to the named queue.
+func NewPostgresNotifier(dsn, queueName string) (*PostgresNotifier, error) {
+	n := &PostgresNotifier{
+		QueueName: queueName,
+		ch:        make(chan struct{}, 1),
+		logger:    logrus.WithField("component", "PostgresNotifier"),
+	}
+
+	n.listener = pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			n.logger.WithError(err).Warn("Notification listener connection problem")
+		}
+	})
+	err := n.listener.Listen(postgresNotifyChannel)
+	if err != nil {
+		n.listener.Close()
+		return nil, err
+	}
+
+	go n.run()
+	return n, nil
+}
+
+// Notifications implements Notifier.
+func (n *PostgresNotifier) Notifications() <-chan struct{} {
+	return n.ch
+}
+
+// Close stops listening.
+func (n *PostgresNotifier) Close() error {
+	return n.listener.Close()
+}
+
+// run forwards the notifications for this queue until the listener is closed.
+func (n *PostgresNotifier) run() {
+	for notification := range n.listener.Notify {
+		// a nil notification means the connection was re-established and notifications may have been missed
+		if notification != nil && notification.Extra != n.QueueName {
+			continue
+		}
+		select {
+		case n.ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNotifyingQueue(t *testing.T) {
+	s := miniredis.RunT(t)
+	inner, err := NewRedisQueue(redis.NewClient(&redis.Options{Addr: s.Addr()}), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewNotifyingQueue(inner)
+
+	// several additions are merged into one notification
+	for _, id := range []string{"a", "b"} {
+		err = q.AddItem(NewQueueItem(id, map[string]any{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-q.Notifications():
+	default:
+		t.Fatal("expected a notification")
+	}
+	select {
+	case <-q.Notifications():
+		t.Fatal("expected notifications to be merged")
+	default:
+	}
+
+	// delayed releases don't notify
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Nack(item, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-q.Notifications():
+		t.Fatal("expected no notification for a delayed item")
+	default:
+	}
+}
@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PostgresQueue represents a queue backed by the queue_items table of a PostgreSQL database,
+// see the postgres package for the schema. Items are claimed with SELECT ... FOR UPDATE SKIP LOCKED,
+// so concurrent workers never block on each other. Adding an item sends a NOTIFY, see PostgresNotifier.
+// Claimed items carry the seq of their row as Receipt, Done and Nack act on that row, so items sharing
+// an ID don't affect each other.
+type PostgresQueue struct {
+	QueueName string
+	// IdempotencyWindow is how long an item's IdempotencyKey blocks duplicates.
+	IdempotencyWindow time.Duration
+	db                *sql.DB
+	logger            *logrus.Entry
+}
+
+// NewPostgresQueue creates a new PostgresQueue instance, db must have been migrated with postgres.Migrate.
+func NewPostgresQueue(db *sql.DB, queueName string) (Queue, error) {
+	err := db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgresQueue{
+		QueueName:         queueName,
+		IdempotencyWindow: DefaultIdempotencyWindow,
+		db:                db,
+		logger:            logrus.WithField("component", "PostgresQueue"),
+	}, nil
+}
+
+// AddItem adds an item to the queue.
+func (q *PostgresQueue) AddItem(queueItem QueueItem) error {
+	data, err := json.Marshal(queueItem)
+	if err != nil {
+		return err
+	}
+
+	var notBefore sql.NullTime
+	if queueItem.NotBefore > 0 {
+		notBefore = sql.NullTime{Time: time.Unix(queueItem.NotBefore, 0), Valid: true}
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if queueItem.IdempotencyKey != "" {
+		// the key is only claimed if it doesn't exist or its window has passed
+		result, err := tx.Exec(`
+			INSERT INTO queue_idempotency (queue, key, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (queue, key) DO UPDATE SET expires_at = EXCLUDED.expires_at
+			WHERE queue_idempotency.expires_at < now()`,
+			q.QueueName, queueItem.IdempotencyKey, time.Now().Add(q.IdempotencyWindow))
+		if err != nil {
+			q.logger.WithError(err).Error("Failed to claim idempotency key")
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			q.logger.WithField("key", queueItem.IdempotencyKey).Infof("Duplicate item dropped from queue: %s", q.QueueName)
+			return ErrDuplicate
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO queue_items (queue, item_id, data, not_before) VALUES ($1, $2, $3, $4)`,
+		q.QueueName, queueItem.ID, data, notBefore)
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to insert item in queue table")
+		return err
+	}
+
+	// wake up PostgresNotifiers, the notification is only delivered if the transaction commits
+	if queueItem.Ready(time.Now()) {
+		_, err = tx.Exec(`SELECT pg_notify($1, $2)`, postgresNotifyChannel, q.QueueName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	q.logger.WithField("ID", queueItem.ID).Infof("Item added to queue: %s", q.QueueName)
+	return nil
+}
+
+// GetNextItem locks and returns the oldest available item from the queue.
+func (q *PostgresQueue) GetNextItem() (QueueItem, error) {
+	return firstItem(q.GetNextItems(1))
+}
+
+// GetNextItems locks and returns up to max of the oldest available items from the queue in one statement.
+func (q *PostgresQueue) GetNextItems(max int) ([]QueueItem, error) {
+	if max <= 0 {
+		return []QueueItem{}, nil
+	}
+
+	rows, err := q.db.Query(`
+		UPDATE queue_items SET locked = TRUE, lock_time = now()
+		WHERE seq IN (
+			SELECT seq FROM queue_items
+			WHERE queue = $1
+				AND (NOT locked OR lock_time < now() - $2::float8 * INTERVAL '1 second')
+				AND (not_before IS NULL OR not_before <= now())
+			ORDER BY seq
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING seq, data`, q.QueueName, LockTimeout.Seconds(), max)
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to claim items from queue table")
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := map[int64]QueueItem{}
+	seqs := []int64{}
+	for rows.Next() {
+		var seq int64
+		var data []byte
+		err = rows.Scan(&seq, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		var item QueueItem
+		err = json.Unmarshal(data, &item)
+		if err != nil {
+			return nil, err
+		}
+		item.Receipt = strconv.FormatInt(seq, 10)
+		claimed[seq] = item
+		seqs = append(seqs, seq)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	if len(seqs) == 0 {
+		q.logger.Infof("No items available for queue: %s", q.QueueName)
+		return []QueueItem{}, nil
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	items := make([]QueueItem, 0, len(seqs))
+	for _, seq := range seqs {
+		items = append(items, claimed[seq])
+	}
+
+	return items, nil
+}
+
+// receiptSeq returns the seq of the row the item was claimed from.
+func receiptSeq(item QueueItem) (int64, error) {
+	seq, err := strconv.ParseInt(item.Receipt, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("item %s has no receipt", item.ID)
+	}
+	return seq, nil
+}
+
+// Done removes the specified item from the queue by its Receipt.
+func (q *PostgresQueue) Done(item QueueItem) error {
+	seq, err := receiptSeq(item)
+	if err != nil {
+		return err
+	}
+
+	result, err := q.db.Exec(`DELETE FROM queue_items WHERE queue = $1 AND seq = $2`, q.QueueName, seq)
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to delete item from queue table")
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		q.logger.Info("No items available in table to remove")
+		return nil
+	}
+
+	q.logger.WithField("ID", item.ID).Info("Item deleted from queue table")
+	return nil
+}
+
+// Nack unlocks the specified item by its Receipt so it is handed out again once delay has passed.
+func (q *PostgresQueue) Nack(item QueueItem, delay time.Duration) error {
+	seq, err := receiptSeq(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.db.Exec(`
+		UPDATE queue_items SET locked = FALSE, lock_time = NULL, not_before = $3
+		WHERE queue = $1 AND seq = $2 AND locked`, q.QueueName, seq, time.Now().Add(delay))
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to unlock item in queue table")
+		return err
+	}
+
+	if delay <= 0 {
+		_, err = q.db.Exec(`SELECT pg_notify($1, $2)`, postgresNotifyChannel, q.QueueName)
+		if err != nil {
+			q.logger.WithError(err).Warn("Failed to notify listeners")
+		}
+	}
+
+	q.logger.WithField("ID", item.ID).Infof("Item released back to queue: %s", q.QueueName)
+	return nil
+}
+
+// Contains reports whether an item with the given ID is still in the queue.
+func (q *PostgresQueue) Contains(id string) (bool, error) {
+	var found bool
+	err := q.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM queue_items WHERE queue = $1 AND item_id = $2)`,
+		q.QueueName, id).Scan(&found)
+	return found, err
+}
@@ -0,0 +1,128 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs-scrape/worker/postgres"
+)
+
+// newTestPostgresQueue connects to the database in IPFS_POSTGRES_TEST_DSN, see the backend package tests.
+func newTestPostgresQueue(t *testing.T) Queue {
+	dsn := os.Getenv("IPFS_POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("IPFS_POSTGRES_TEST_DSN not set")
+	}
+
+	db, err := postgres.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`TRUNCATE queue_items, queue_idempotency`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewPostgresQueue(db, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestPostgresQueue(t *testing.T) {
+	q := newTestPostgresQueue(t)
+
+	later := NewScheduledQueueItem("later", map[string]any{}, time.Now().Add(time.Hour))
+	now := NewQueueItem("now", map[string]any{"cids": []any{"cid"}})
+	now.IdempotencyKey = "now"
+
+	for _, item := range []QueueItem{later, now} {
+		err := q.AddItem(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := q.AddItem(now)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != "now" {
+		t.Errorf("expected item now, got %s", item.ID)
+	}
+
+	// the duplicate was dropped and the scheduled item isn't due yet
+	_, err = q.GetNextItem()
+	if err == nil {
+		t.Error("expected no more items")
+	}
+
+	err = q.Done(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := q.Contains("now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("expected done item to be removed")
+	}
+}
+
+func TestPostgresQueueDuplicateIDs(t *testing.T) {
+	q := newTestPostgresQueue(t)
+
+	for i := 0; i < 2; i++ {
+		err := q.AddItem(NewQueueItem("dup", map[string]any{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := q.GetNextItems(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Receipt == items[1].Receipt {
+		t.Fatalf("expected 2 items with their own receipts, got %+v", items)
+	}
+
+	// the second item is acked and the first released, each acts on its own row
+	err = q.Done(items[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Nack(items[0], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Receipt != items[0].Receipt {
+		t.Errorf("expected the released item back, got %+v", item)
+	}
+	err = q.Done(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := q.Contains("dup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("expected both items to be removed")
+	}
+}
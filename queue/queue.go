@@ -5,15 +5,32 @@ Refactoring to a more general package can be later or never :)
 
 package queue
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockTimeout is how long a locked item stays invisible before another worker may pick it up again.
+const LockTimeout = time.Hour
+
+// ErrDuplicate is returned by AddItem when the item was dropped because its IdempotencyKey was taken.
+var ErrDuplicate = errors.New("duplicate item dropped")
 
 type Queue interface {
-	// AddItem adds an item to the queue.
+	// AddItem adds an item to the queue. Items with a NotBefore time are held back until that time.
+	// It returns ErrDuplicate if the item was dropped as a duplicate.
 	AddItem(item QueueItem) error
-	// GetNextItem returns the next item in the queue.
+	// GetNextItem returns the next item in the queue whose NotBefore time has passed.
 	GetNextItem() (QueueItem, error)
+	// GetNextItems locks and returns up to max items in one round trip. An empty queue returns no items and no error.
+	GetNextItems(max int) ([]QueueItem, error)
 	// Done( QueueItem ) marks the item as done.
 	Done(item QueueItem) error
+	// Nack releases a locked item back to the queue, it is handed out again once delay has passed.
+	Nack(item QueueItem, delay time.Duration) error
+	// Contains reports whether an item with the given ID is waiting or in flight.
+	Contains(id string) (bool, error)
 }
 
 // QueueItem represents an item in the DynamoDB queue.
@@ -21,6 +38,14 @@ type QueueItem struct {
 	ID        string         `json:"id"`
 	Data      map[string]any `json:"data"`
 	CreatedAt int64          `json:"created_at"`
+	// NotBefore is the unix time before which the item will not be handed out by GetNextItem.
+	// Zero means the item is available immediately.
+	NotBefore int64 `json:"not_before,omitempty"`
+	// IdempotencyKey is optional. A second item with the same key added within the queue's
+	// idempotency window is dropped instead of being enqueued again, and AddItem returns ErrDuplicate.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Receipt identifies this delivery of the item for queues that need it to acknowledge it, e.g. SQS and Redis.
+	Receipt string `json:"-"`
 }
 
 // NewQueueItem creates a new QueueItem with the given ID and data.
@@ -31,3 +56,26 @@ func NewQueueItem(id string, data map[string]any) QueueItem {
 		CreatedAt: time.Now().Unix(),
 	}
 }
+
+// NewScheduledQueueItem creates a new QueueItem that only becomes available at or after notBefore.
+func NewScheduledQueueItem(id string, data map[string]any, notBefore time.Time) QueueItem {
+	item := NewQueueItem(id, data)
+	item.NotBefore = notBefore.Unix()
+	return item
+}
+
+// Ready reports whether the item may be handed out at the given time.
+func (i QueueItem) Ready(now time.Time) bool {
+	return i.NotBefore == 0 || i.NotBefore <= now.Unix()
+}
+
+// firstItem turns the result of GetNextItems(1) into the result of GetNextItem.
+func firstItem(items []QueueItem, err error) (QueueItem, error) {
+	if err != nil {
+		return QueueItem{}, err
+	}
+	if len(items) == 0 {
+		return QueueItem{}, fmt.Errorf("no items available in queue")
+	}
+	return items[0], nil
+}
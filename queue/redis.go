@@ -0,0 +1,233 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// RedisQueue represents a queue backed by Redis. Every queue uses the following keys:
+//
+//   - <queue>:ready, a sorted set of waiting items scored by the unix milliseconds they become available
+//   - <queue>:locked, a sorted set of claimed items scored by the unix milliseconds their lock expires
+//   - <queue>:items, a hash from item key to QueueItem payload
+//   - <queue>:idcount, a hash from QueueItem ID to the number of queued items with that ID
+//   - <queue>:idem:<key>, an idempotency key that expires after the idempotency window
+//
+// Item keys start with the time the item was added, so items available at the same time are handed out in order.
+// Claimed items carry their item key as Receipt, Done and Nack act on that key, so items sharing an ID don't
+// affect each other. All state changes run as Lua scripts, so they are atomic.
+type RedisQueue struct {
+	QueueName string
+	// IdempotencyWindow is how long an item's IdempotencyKey blocks duplicates.
+	IdempotencyWindow time.Duration
+	client            redis.UniversalClient
+	logger            *logrus.Entry
+}
+
+// NewRedisQueue creates a new RedisQueue instance.
+func NewRedisQueue(client redis.UniversalClient, queueName string) (Queue, error) {
+	err := client.Ping(context.Background()).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisQueue{
+		QueueName:         queueName,
+		IdempotencyWindow: DefaultIdempotencyWindow,
+		client:            client,
+		logger:            logrus.WithField("component", "RedisQueue"),
+	}, nil
+}
+
+// key returns a key of this queue, the hash tag keeps all keys of a queue in one cluster slot.
+func (q *RedisQueue) key(name string) string {
+	return fmt.Sprintf("{queue-%s}:%s", q.QueueName, name)
+}
+
+// enqueueScript adds an item unless its idempotency key is taken.
+// KEYS: ready, items, idcount, idempotency key (or the ready key if there is none)
+// ARGV: item key, payload, not before ms, item ID, idempotency window ms (0 without a key)
+var enqueueScript = redis.NewScript(`
+if tonumber(ARGV[5]) > 0 then
+	if not redis.call('SET', KEYS[4], ARGV[1], 'NX', 'PX', ARGV[5]) then
+		return 0
+	end
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('HINCRBY', KEYS[3], ARGV[4], 1)
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// claimScript returns items with an expired lock to the ready set, then locks up to count ready items
+// and returns their item keys and payloads, alternating.
+// KEYS: ready, locked, items
+// ARGV: now ms, lock expiry ms, count
+var claimScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, member in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], member)
+	redis.call('ZADD', KEYS[1], ARGV[1], member)
+end
+local next = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+local payloads = {}
+for _, member in ipairs(next) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('ZADD', KEYS[2], ARGV[2], member)
+	table.insert(payloads, member)
+	table.insert(payloads, redis.call('HGET', KEYS[3], member))
+end
+return payloads
+`)
+
+// ackScript removes an item by its item key.
+// KEYS: ready, locked, items, idcount
+// ARGV: item key, item ID
+var ackScript = redis.NewScript(`
+if redis.call('HDEL', KEYS[3], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+if redis.call('HINCRBY', KEYS[4], ARGV[2], -1) <= 0 then
+	redis.call('HDEL', KEYS[4], ARGV[2])
+end
+return 1
+`)
+
+// nackScript moves a locked item back to the ready set by its item key, available at the given time.
+// KEYS: ready, locked
+// ARGV: item key, not before ms
+var nackScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[2], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1
+`)
+
+// AddItem adds an item to the queue.
+func (q *RedisQueue) AddItem(queueItem QueueItem) error {
+	payload, err := json.Marshal(queueItem)
+	if err != nil {
+		return err
+	}
+
+	notBefore := time.Now()
+	if queueItem.NotBefore > 0 {
+		notBefore = time.Unix(queueItem.NotBefore, 0)
+	}
+
+	idemKey := q.key("ready")
+	window := int64(0)
+	if queueItem.IdempotencyKey != "" {
+		idemKey = q.key("idem:" + queueItem.IdempotencyKey)
+		window = q.IdempotencyWindow.Milliseconds()
+	}
+
+	// items with the same score are ordered by key, so the key starts with the zero padded time
+	member := fmt.Sprintf("%020d-%s", time.Now().UnixNano(), uuid.New().String())
+	added, err := enqueueScript.Run(context.Background(), q.client,
+		[]string{q.key("ready"), q.key("items"), q.key("idcount"), idemKey},
+		member, payload, notBefore.UnixMilli(), queueItem.ID, window).Int()
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to add item to Redis")
+		return err
+	}
+	if added == 0 {
+		q.logger.WithField("key", queueItem.IdempotencyKey).Infof("Duplicate item dropped from queue: %s", q.QueueName)
+		return ErrDuplicate
+	}
+
+	q.logger.WithField("ID", queueItem.ID).Infof("Item added to queue: %s", q.QueueName)
+	return nil
+}
+
+// GetNextItem locks and returns the next available item from the queue.
+func (q *RedisQueue) GetNextItem() (QueueItem, error) {
+	return firstItem(q.GetNextItems(1))
+}
+
+// GetNextItems locks and returns up to max available items from the queue in one script call.
+func (q *RedisQueue) GetNextItems(max int) ([]QueueItem, error) {
+	if max <= 0 {
+		return []QueueItem{}, nil
+	}
+
+	now := time.Now()
+	payloads, err := claimScript.Run(context.Background(), q.client,
+		[]string{q.key("ready"), q.key("locked"), q.key("items")},
+		now.UnixMilli(), now.Add(LockTimeout).UnixMilli(), max).StringSlice()
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to claim items from Redis")
+		return nil, err
+	}
+	if len(payloads) == 0 {
+		q.logger.Infof("No items available for queue: %s", q.QueueName)
+		return []QueueItem{}, nil
+	}
+
+	items := make([]QueueItem, 0, len(payloads)/2)
+	for i := 0; i+1 < len(payloads); i += 2 {
+		var item QueueItem
+		err = json.Unmarshal([]byte(payloads[i+1]), &item)
+		if err != nil {
+			return nil, err
+		}
+		item.Receipt = payloads[i]
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Done removes the specified item from the queue by its Receipt.
+func (q *RedisQueue) Done(item QueueItem) error {
+	if item.Receipt == "" {
+		return fmt.Errorf("item %s has no receipt", item.ID)
+	}
+
+	removed, err := ackScript.Run(context.Background(), q.client,
+		[]string{q.key("ready"), q.key("locked"), q.key("items"), q.key("idcount")},
+		item.Receipt, item.ID).Int()
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to remove item from Redis")
+		return err
+	}
+	if removed == 0 {
+		q.logger.Info("No items available to remove")
+		return nil
+	}
+
+	q.logger.WithField("ID", item.ID).Info("Item removed from Redis")
+	return nil
+}
+
+// Nack unlocks the specified item by its Receipt so it is handed out again once delay has passed.
+func (q *RedisQueue) Nack(item QueueItem, delay time.Duration) error {
+	if item.Receipt == "" {
+		return fmt.Errorf("item %s has no receipt", item.ID)
+	}
+
+	_, err := nackScript.Run(context.Background(), q.client,
+		[]string{q.key("ready"), q.key("locked")},
+		item.Receipt, time.Now().Add(delay).UnixMilli()).Int()
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to release item in Redis")
+		return err
+	}
+
+	q.logger.WithField("ID", item.ID).Infof("Item released back to queue: %s", q.QueueName)
+	return nil
+}
+
+// Contains reports whether an item with the given ID is still in the queue.
+func (q *RedisQueue) Contains(id string) (bool, error) {
+	return q.client.HExists(context.Background(), q.key("idcount"), id).Result()
+}
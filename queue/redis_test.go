@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisQueue(t *testing.T) {
+	s := miniredis.RunT(t)
+	q, err := NewRedisQueue(redis.NewClient(&redis.Options{Addr: s.Addr()}), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := NewScheduledQueueItem("later", map[string]any{}, time.Now().Add(time.Hour))
+	now := NewQueueItem("now", map[string]any{"cids": []any{"cid"}})
+	now.IdempotencyKey = "now"
+
+	for _, item := range []QueueItem{later, now} {
+		err = q.AddItem(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = q.AddItem(now)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != "now" {
+		t.Errorf("expected item now, got %s", item.ID)
+	}
+
+	// the duplicate was dropped and the scheduled item isn't due yet
+	_, err = q.GetNextItem()
+	if err == nil {
+		t.Error("expected no more items")
+	}
+
+	// a nacked item comes back
+	err = q.Nack(item, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err = q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = q.Done(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := q.Contains("now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("expected done item to be removed")
+	}
+}
+
+func TestRedisQueueGetNextItems(t *testing.T) {
+	s := miniredis.RunT(t)
+	q, err := NewRedisQueue(redis.NewClient(&redis.Options{Addr: s.Addr()}), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		err = q.AddItem(NewQueueItem(id, map[string]any{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := q.GetNextItems(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(items))
+	}
+
+	items, err = q.GetNextItems(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != "c" {
+		t.Errorf("expected the remaining item c, got %+v", items)
+	}
+
+	items, err = q.GetNextItems(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("expected an empty queue, got %+v", items)
+	}
+}
+
+func TestRedisQueueDuplicateIDs(t *testing.T) {
+	s := miniredis.RunT(t)
+	q, err := NewRedisQueue(redis.NewClient(&redis.Options{Addr: s.Addr()}), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// items without an idempotency key may share an ID, e.g. a requeue of a failed item
+	for i := 0; i < 2; i++ {
+		err = q.AddItem(NewQueueItem("dup", map[string]any{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, err := q.GetNextItems(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Receipt == items[1].Receipt {
+		t.Fatalf("expected 2 items with their own receipts, got %+v", items)
+	}
+
+	// acking one item leaves the other locked
+	err = q.Done(items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := q.Contains("dup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Error("expected the second item to be queued still")
+	}
+
+	err = q.Nack(items[1], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Receipt != items[1].Receipt {
+		t.Errorf("expected the nacked item back, got %+v", item)
+	}
+
+	err = q.Done(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err = q.Contains("dup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("expected both items to be removed")
+	}
+	_, err = q.GetNextItem()
+	if err == nil {
+		t.Error("expected no orphaned item to be handed out")
+	}
+}
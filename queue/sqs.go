@@ -0,0 +1,273 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+)
+
+// sqsMaxDelay is the longest delay SQS accepts when sending a message.
+const sqsMaxDelay = 15 * time.Minute
+
+// sqsMaxMessages is the most messages SQS returns from one receive.
+const sqsMaxMessages = 10
+
+// sqsMaxVisibility is the longest visibility timeout SQS accepts.
+const sqsMaxVisibility = 12 * time.Hour
+
+// SQSQueue represents a queue backed by an SQS queue.
+//
+// Received messages stay invisible for LockTimeout, just like locked DynamoDB items, and Done deletes them
+// by their receipt handle. Failed items are released with Nack, so after the queue's redrive policy
+// maxReceiveCount they are moved to its dead-letter queue by SQS itself, see SetRedrivePolicy.
+//
+// Items are delayed with the message's DelaySeconds, which SQS caps at 15 minutes. Items scheduled further
+// ahead are received early and sent again with the remaining delay, so waiting doesn't count towards the
+// redrive policy. FIFO queues have no per message delay, so they reject scheduled items.
+//
+// SQS can't look messages up, so Contains always reports false. On FIFO queues the IdempotencyKey is
+// sent as the message deduplication ID, which SQS enforces for five minutes; standard queues ignore it.
+type SQSQueue struct {
+	QueueURL string
+	// WaitTime is how long GetNextItem long-polls for a message, at most 20 seconds.
+	WaitTime time.Duration
+	svc      *sqs.SQS
+	logger   *logrus.Entry
+}
+
+// NewSQSQueue creates a new SQSQueue instance.
+func NewSQSQueue(queueURL string, svc *sqs.SQS) (*SQSQueue, error) {
+	// Check if the queue exists
+	_, err := svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQSQueue{
+		QueueURL: queueURL,
+		WaitTime: 20 * time.Second,
+		svc:      svc,
+		logger:   logrus.WithField("component", "SQSQueue"),
+	}, nil
+}
+
+// fifo reports whether the queue is a FIFO queue.
+func (q *SQSQueue) fifo() bool {
+	return strings.HasSuffix(q.QueueURL, ".fifo")
+}
+
+// SetRedrivePolicy moves messages that were received maxReceiveCount times without being done
+// to the dead-letter queue with the given ARN.
+func (q *SQSQueue) SetRedrivePolicy(deadLetterARN string, maxReceiveCount int) error {
+	policy, err := json.Marshal(map[string]string{
+		"deadLetterTargetArn": deadLetterARN,
+		"maxReceiveCount":     strconv.Itoa(maxReceiveCount),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.svc.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl:   aws.String(q.QueueURL),
+		Attributes: map[string]*string{sqs.QueueAttributeNameRedrivePolicy: aws.String(string(policy))},
+	})
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to set redrive policy")
+		return err
+	}
+
+	return nil
+}
+
+// AddItem sends an item to the queue. Items scheduled more than 15 minutes ahead are delivered early
+// and sent again by GetNextItems until they are ready. FIFO queues fail for scheduled items.
+func (q *SQSQueue) AddItem(queueItem QueueItem) error {
+	delay := time.Duration(0)
+	if queueItem.NotBefore > 0 {
+		delay = time.Until(time.Unix(queueItem.NotBefore, 0))
+	}
+	if q.fifo() && delay > 0 {
+		return fmt.Errorf("item %s is scheduled, but FIFO queues don't support per message delays", queueItem.ID)
+	}
+
+	err := q.send(queueItem, delay)
+	if err != nil {
+		return err
+	}
+
+	q.logger.WithField("ID", queueItem.ID).Infof("Item added to queue: %s", q.QueueURL)
+	return nil
+}
+
+// send sends an item that becomes visible after delay, at most sqsMaxDelay.
+func (q *SQSQueue) send(queueItem QueueItem, delay time.Duration) error {
+	body, err := json.Marshal(queueItem)
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.QueueURL),
+		MessageBody: aws.String(string(body)),
+	}
+	if q.fifo() {
+		input.MessageGroupId = aws.String(queueItem.ID)
+		if queueItem.IdempotencyKey != "" {
+			input.MessageDeduplicationId = aws.String(queueItem.IdempotencyKey)
+		}
+	} else if delay > 0 {
+		if delay > sqsMaxDelay {
+			delay = sqsMaxDelay
+		}
+		// round up, an item delivered a little early would only be sent again
+		input.DelaySeconds = aws.Int64(int64((delay + time.Second - 1) / time.Second))
+	}
+
+	_, err = q.svc.SendMessage(input)
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to send message to SQS queue")
+		return err
+	}
+
+	return nil
+}
+
+// GetNextItem long-polls for the next item and hides it from other consumers for LockTimeout.
+func (q *SQSQueue) GetNextItem() (QueueItem, error) {
+	return firstItem(q.GetNextItems(1))
+}
+
+// GetNextItems long-polls for up to max items, at most 10 per call, and hides them from other consumers for LockTimeout.
+func (q *SQSQueue) GetNextItems(max int) ([]QueueItem, error) {
+	if max <= 0 {
+		return []QueueItem{}, nil
+	}
+	if max > sqsMaxMessages {
+		max = sqsMaxMessages
+	}
+
+	result, err := q.svc.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.QueueURL),
+		MaxNumberOfMessages: aws.Int64(int64(max)),
+		WaitTimeSeconds:     aws.Int64(int64(q.WaitTime.Seconds())),
+		VisibilityTimeout:   aws.Int64(int64(LockTimeout.Seconds())),
+	})
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to receive message from SQS queue")
+		return nil, err
+	}
+	if len(result.Messages) == 0 {
+		q.logger.Infof("No items available for queue: %s", q.QueueURL)
+		return []QueueItem{}, nil
+	}
+
+	now := time.Now()
+	items := make([]QueueItem, 0, len(result.Messages))
+	for _, message := range result.Messages {
+		// a message that isn't an item stays hidden, the redrive policy eventually moves it to the dead-letter queue
+		var item QueueItem
+		err = json.Unmarshal([]byte(aws.StringValue(message.Body)), &item)
+		if err != nil {
+			q.logger.WithError(err).WithField("MessageId", aws.StringValue(message.MessageId)).Error("Skipping message that isn't a queue item")
+			continue
+		}
+		item.Receipt = aws.StringValue(message.ReceiptHandle)
+
+		// items delivered before their time are sent again with the remaining delay. FIFO queues don't take
+		// scheduled items, and sending one again there would be dropped as a duplicate, so it's handed out.
+		if !item.Ready(now) && !q.fifo() {
+			err = q.resend(item, time.Unix(item.NotBefore, 0).Sub(now))
+			if err != nil {
+				q.logger.WithError(err).WithField("ID", item.ID).Error("Failed to send early item again")
+			}
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// resend sends a received item again as a new message that becomes visible after delay, then deletes the received one.
+// Unlike hiding the received message, this doesn't count as a receive towards the redrive policy.
+func (q *SQSQueue) resend(item QueueItem, delay time.Duration) error {
+	receipt := item.Receipt
+	item.Receipt = ""
+	err := q.send(item, delay)
+	if err != nil {
+		return err
+	}
+
+	item.Receipt = receipt
+	return q.Done(item)
+}
+
+// Done deletes the specified item from the queue by its receipt handle.
+func (q *SQSQueue) Done(item QueueItem) error {
+	if item.Receipt == "" {
+		return fmt.Errorf("item %s has no receipt handle", item.ID)
+	}
+
+	_, err := q.svc.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.QueueURL),
+		ReceiptHandle: aws.String(item.Receipt),
+	})
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to delete message from SQS queue")
+		return err
+	}
+
+	q.logger.WithField("ID", item.ID).Info("Item deleted from SQS")
+	return nil
+}
+
+// Nack makes the specified item visible again once delay has passed, at most 12 hours.
+// Like every receive, a nacked item counts towards the redrive policy's maxReceiveCount.
+func (q *SQSQueue) Nack(item QueueItem, delay time.Duration) error {
+	if delay > sqsMaxVisibility {
+		delay = sqsMaxVisibility
+	}
+
+	err := q.changeVisibility(item, delay)
+	if err != nil {
+		return err
+	}
+
+	q.logger.WithField("ID", item.ID).Infof("Item released back to queue: %s", q.QueueURL)
+	return nil
+}
+
+// Contains always reports false, SQS messages can't be looked up by ID.
+// A scheduler.RefreshScheduler can't tell whether a refresh is queued already, so it needs a FIFO queue.
+func (q *SQSQueue) Contains(id string) (bool, error) {
+	return false, nil
+}
+
+// changeVisibility hides a received item for the given time.
+func (q *SQSQueue) changeVisibility(item QueueItem, timeout time.Duration) error {
+	if item.Receipt == "" {
+		return fmt.Errorf("item %s has no receipt handle", item.ID)
+	}
+
+	_, err := q.svc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.QueueURL),
+		ReceiptHandle:     aws.String(item.Receipt),
+		VisibilityTimeout: aws.Int64(int64(timeout.Seconds())),
+	})
+	if err != nil {
+		q.logger.WithError(err).Error("Failed to change message visibility in SQS queue")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// newTestSQSQueue creates a queue on the SQS compatible server in IPFS_SQS_TEST_ENDPOINT, e.g. ElasticMQ.
+func newTestSQSQueue(t *testing.T) *SQSQueue {
+	endpoint := os.Getenv("IPFS_SQS_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("IPFS_SQS_TEST_ENDPOINT not set")
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := sqs.New(sess)
+
+	created, err := svc.CreateQueue(&sqs.CreateQueueInput{
+		QueueName: aws.String(fmt.Sprintf("test-%d", time.Now().UnixNano())),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: created.QueueUrl}) })
+
+	q, err := NewSQSQueue(aws.StringValue(created.QueueUrl), svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.WaitTime = time.Second
+	return q
+}
+
+func TestSQSQueue(t *testing.T) {
+	q := newTestSQSQueue(t)
+
+	err := q.AddItem(NewQueueItem("now", map[string]any{"cids": []any{"cid"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != "now" || item.Receipt == "" {
+		t.Errorf("expected item now with a receipt, got %+v", item)
+	}
+
+	// a nacked item comes back
+	err = q.Nack(item, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err = q.GetNextItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = q.Done(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.GetNextItem()
+	if err == nil {
+		t.Error("expected no more items")
+	}
+}
+
+func TestSQSQueueSkipsEarlyAndBadMessages(t *testing.T) {
+	q := newTestSQSQueue(t)
+
+	_, err := q.svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.QueueURL),
+		MessageBody: aws.String("not an item"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an item scheduled beyond the longest SQS delay is delivered right away and sent again
+	later := NewScheduledQueueItem("later", map[string]any{}, time.Now().Add(time.Hour))
+	err = q.AddItem(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.AddItem(NewQueueItem("now", map[string]any{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []QueueItem{}
+	for attempt := 0; attempt < 3 && len(items) == 0; attempt++ {
+		items, err = q.GetNextItems(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(items) != 1 || items[0].ID != "now" {
+		t.Errorf("expected only item now, got %+v", items)
+	}
+}
@@ -0,0 +1,169 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/ipfs-scrape/worker/queue"
+	"github.com/sirupsen/logrus"
+)
+
+// scanPageSize is how many records the scheduler holds in memory at once.
+const scanPageSize = 100
+
+// RefreshScheduler periodically scans the backend for stale Metadata records and enqueues refresh jobs for them.
+type RefreshScheduler struct {
+	queue  queue.Queue
+	store  *backend.Store[ipfs.Metadata]
+	logger *logrus.Entry
+
+	// ttl is how old a record's FetchedAt may get before it is refreshed
+	ttl time.Duration
+	// maxJobs caps how many refresh jobs are enqueued per scan
+	maxJobs int
+	// cursor is where the next scan picks up
+	cursor string
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	pollTime time.Duration
+}
+
+// NewRefreshScheduler creates a new RefreshScheduler that scans every pollTime and enqueues at most maxJobs
+// refresh jobs per scan for records older than ttl.
+// A record that is still stale is skipped while q.Contains reports its refresh job. Queues that can't look
+// items up, like queue.SQSQueue, always report false, so on them only the idempotency key keeps a job from
+// being enqueued again. Standard SQS queues ignore that too, so q must not be one: every scan would enqueue
+// the stale records again.
+func NewRefreshScheduler(q queue.Queue, b backend.Backend, ttl time.Duration, pollTime time.Duration, maxJobs int) *RefreshScheduler {
+	return &RefreshScheduler{
+		queue:    q,
+		store:    backend.NewStore[ipfs.Metadata](b),
+		logger:   logrus.WithField("component", "RefreshScheduler"),
+		ttl:      ttl,
+		pollTime: pollTime,
+		maxJobs:  maxJobs,
+	}
+}
+
+// RefreshItemID returns the queue item ID used for refreshing the given CID.
+// Using a stable ID lets the scheduler see whether a refresh is already queued.
+func RefreshItemID(cid string) string {
+	return fmt.Sprintf("refresh-%s", cid)
+}
+
+// Run starts the RefreshScheduler in the background.
+func (s *RefreshScheduler) Run() {
+	s.stopCh = make(chan struct{})
+	s.doneCh = make(chan struct{})
+
+	ticker := time.NewTicker(s.pollTime)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := s.Schedule()
+				if err != nil {
+					s.logger.WithError(err).Error("Failed to schedule refresh jobs")
+					continue
+				}
+				s.logger.Infof("Enqueued %d refresh jobs", n)
+
+			case <-s.stopCh:
+				s.logger.Info("Stopping RefreshScheduler")
+				close(s.doneCh)
+				return
+			}
+		}
+	}()
+}
+
+// Wait waits for the RefreshScheduler to stop.
+func (s *RefreshScheduler) Wait() {
+	<-s.doneCh
+}
+
+// Stop stops the RefreshScheduler.
+func (s *RefreshScheduler) Stop() {
+	close(s.stopCh)
+}
+
+// Schedule performs a single scan and returns the number of refresh jobs enqueued.
+// A scan that stops at maxJobs resumes from the same page on the next call instead of starting over.
+func (s *RefreshScheduler) Schedule() (int, error) {
+	staleBefore := time.Now().Add(-s.ttl).Unix()
+	enqueued := 0
+
+	for {
+		records, next, err := s.store.ListPage(ipfs.MetadataIDPrefix, s.cursor, scanPageSize)
+		if err != nil {
+			return enqueued, err
+		}
+
+		for _, metadata := range records {
+			if enqueued >= s.maxJobs {
+				s.logger.Infof("Reached the limit of %d refresh jobs for this scan", s.maxJobs)
+				return enqueued, nil
+			}
+
+			if metadata.CID == "" {
+				continue
+			}
+			// records written before FetchedAt existed have zero, so they are always stale
+			if metadata.FetchedAt >= staleBefore {
+				continue
+			}
+
+			ok, err := s.enqueue(metadata.CID)
+			if err != nil {
+				return enqueued, err
+			}
+			if ok {
+				enqueued++
+			}
+		}
+
+		s.cursor = next
+		if next == "" {
+			return enqueued, nil
+		}
+	}
+}
+
+// enqueue adds a refresh job for cid unless one is already queued.
+func (s *RefreshScheduler) enqueue(cid string) (bool, error) {
+	id := RefreshItemID(cid)
+	queued, err := s.queue.Contains(id)
+	if err != nil {
+		return false, err
+	}
+	if queued {
+		s.logger.WithField("CID", cid).Debug("Refresh already queued")
+		return false, nil
+	}
+
+	item := queue.NewQueueItem(id, map[string]any{
+		"cids":  []any{cid},
+		"force": true,
+	})
+	// the idempotency key guards against a refresh being enqueued twice by concurrent schedulers.
+	// It changes every ttl, so a refresh that got lost is enqueued again once the record is due again
+	// however long the queue's idempotency window is.
+	item.IdempotencyKey = fmt.Sprintf("%s-%d", id, time.Now().Truncate(s.ttl).Unix())
+	err = s.queue.AddItem(item)
+	if errors.Is(err, queue.ErrDuplicate) {
+		s.logger.WithField("CID", cid).Debug("Refresh enqueued by another scheduler")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/ipfs-scrape/worker/queue"
+)
+
+// memoryQueue keeps added items and drops items whose idempotency key it has seen.
+type memoryQueue struct {
+	queue.Queue
+	items []queue.QueueItem
+	keys  map[string]bool
+}
+
+func (q *memoryQueue) AddItem(item queue.QueueItem) error {
+	if q.keys == nil {
+		q.keys = map[string]bool{}
+	}
+	if q.keys[item.IdempotencyKey] {
+		return queue.ErrDuplicate
+	}
+	q.keys[item.IdempotencyKey] = true
+	q.items = append(q.items, item)
+	return nil
+}
+
+func (q *memoryQueue) Contains(id string) (bool, error) {
+	for _, item := range q.items {
+		if item.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pageBackend lists its records in ID order, its cursor is the ID of the last record returned.
+type pageBackend struct {
+	backend.Backend
+	records []ipfs.Metadata
+}
+
+func (b pageBackend) ScanPage(prefix string, cursor string, limit int) ([]any, string, error) {
+	start := sort.Search(len(b.records), func(i int) bool { return b.records[i].ID > cursor })
+	end := start + limit
+	if end >= len(b.records) {
+		end = len(b.records)
+	}
+
+	page := []any{}
+	for _, record := range b.records[start:end] {
+		page = append(page, record)
+	}
+	if end == len(b.records) {
+		return page, "", nil
+	}
+	return page, b.records[end-1].ID, nil
+}
+
+// staleRecords returns n records in ID order that were fetched two hours ago.
+func staleRecords(n int) []ipfs.Metadata {
+	records := make([]ipfs.Metadata, 0, n)
+	for i := 0; i < n; i++ {
+		cid := fmt.Sprintf("cid%03d", i)
+		records = append(records, ipfs.Metadata{
+			ID:        ipfs.GenerateIDFromCID(cid),
+			CID:       cid,
+			FetchedAt: time.Now().Add(-2 * time.Hour).Unix(),
+		})
+	}
+	return records
+}
+
+func TestScheduleSelectsStaleRecords(t *testing.T) {
+	b := pageBackend{records: []ipfs.Metadata{
+		{ID: "d-fresh", CID: "fresh", FetchedAt: time.Now().Unix()},
+		{ID: "d-legacy", CID: "legacy"},
+		{ID: "d-nocid", FetchedAt: time.Now().Add(-2 * time.Hour).Unix()},
+		{ID: "d-stale", CID: "stale", FetchedAt: time.Now().Add(-2 * time.Hour).Unix()},
+	}}
+	q := &memoryQueue{}
+	s := NewRefreshScheduler(q, b, time.Hour, time.Minute, 10)
+
+	n, err := s.Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(q.items) != 2 {
+		t.Fatalf("expected 2 refresh jobs, got %d and %+v", n, q.items)
+	}
+
+	// records without FetchedAt count as stale, records without a CID can't be refreshed
+	for i, cid := range []string{"legacy", "stale"} {
+		item := q.items[i]
+		if item.ID != RefreshItemID(cid) {
+			t.Errorf("expected a refresh of %s, got %s", cid, item.ID)
+		}
+		if cids := item.Data["cids"].([]any); len(cids) != 1 || cids[0] != cid || item.Data["force"] != true {
+			t.Errorf("expected a forced fetch of %s, got %v", cid, item.Data)
+		}
+	}
+}
+
+func TestScheduleCapsJobsAndResumes(t *testing.T) {
+	b := pageBackend{records: staleRecords(150)}
+	q := &memoryQueue{}
+	s := NewRefreshScheduler(q, b, time.Hour, time.Minute, 120)
+
+	// the first scan stops at the cap in the second page and keeps the cursor at its start
+	n, err := s.Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 120 {
+		t.Errorf("expected 120 refresh jobs, got %d", n)
+	}
+	if s.cursor != b.records[scanPageSize-1].ID {
+		t.Errorf("expected the cursor at the end of the first page, got %q", s.cursor)
+	}
+
+	// the second scan picks up the rest of the second page, skipping what is queued already, then starts over
+	n, err = s.Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 30 {
+		t.Errorf("expected the remaining 30 refresh jobs, got %d", n)
+	}
+	if s.cursor != "" {
+		t.Errorf("expected the cursor to be reset after the last page, got %q", s.cursor)
+	}
+	if len(q.items) != 150 {
+		t.Errorf("expected one refresh job per record, got %d", len(q.items))
+	}
+
+	// every record is queued, so a full scan enqueues nothing
+	n, err = s.Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected queued refreshes to be skipped, got %d new jobs", n)
+	}
+}
+
+func TestScheduleCountsDuplicatesAsNotEnqueued(t *testing.T) {
+	b := pageBackend{records: staleRecords(3)}
+	q := &memoryQueue{}
+	s := NewRefreshScheduler(q, b, time.Hour, time.Minute, 10)
+
+	n, err := s.Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 refresh jobs, got %d", n)
+	}
+
+	// another scheduler's queue doesn't hold the jobs, but their idempotency keys are taken within the TTL
+	other := NewRefreshScheduler(&memoryQueue{keys: q.keys}, b, time.Hour, time.Minute, 10)
+	n, err = other.Schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected duplicates not to count as enqueued, got %d", n)
+	}
+}
@@ -0,0 +1,58 @@
+package search
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServeHTTP answers GET requests with a JSON Response. Supported query parameters are q, image_cid,
+// from, size and trait.<name>=<value> for every trait that must match.
+func (i *Index) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	req := Request{
+		Query:    params.Get("q"),
+		ImageCID: params.Get("image_cid"),
+		Traits:   map[string]any{},
+	}
+	for name, values := range params {
+		if trait := strings.TrimPrefix(name, "trait."); trait != name && len(values) > 0 {
+			req.Traits[trait] = values[0]
+		}
+	}
+
+	var err error
+	if from := params.Get("from"); from != "" {
+		req.From, err = strconv.Atoi(from)
+		if err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+	}
+	if size := params.Get("size"); size != "" {
+		req.Size, err = strconv.Atoi(size)
+		if err != nil {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	response, err := i.Search(req)
+	if err != nil {
+		i.logger.WithError(err).Error("Failed to search index")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		i.logger.WithError(err).Error("Failed to write search response")
+	}
+}
@@ -0,0 +1,394 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+	"github.com/sirupsen/logrus"
+)
+
+// rebuildBatchSize is how many records are indexed per batch while rebuilding.
+const rebuildBatchSize = 500
+
+// Index is an embedded full-text index over ipfs.Metadata, stored on disk.
+type Index struct {
+	path   string
+	logger *logrus.Entry
+
+	// mu guards index, which is swapped out by Rebuild
+	mu    sync.RWMutex
+	index bleve.Index
+
+	// writes made while Rebuild runs are kept in buffer and replayed on the new index before the swap,
+	// buffer is nil when no rebuild runs
+	bufferMu sync.Mutex
+	buffer   []write
+}
+
+// write is a change made to the index, an index of metadata or a delete of deleteID.
+type write struct {
+	metadata []ipfs.Metadata
+	deleteID string
+}
+
+// document is what gets indexed for a Metadata record.
+type document struct {
+	CID         string   `json:"cid"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Attributes  []string `json:"attributes"`
+	Traits      []string `json:"traits"`
+	ImageCID    string   `json:"image_cid"`
+}
+
+// Open opens the index at path, creating it if it doesn't exist yet.
+func Open(path string) (*Index, error) {
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, newMapping())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{
+		path:   path,
+		logger: logrus.WithField("component", "SearchIndex"),
+		index:  index,
+	}, nil
+}
+
+// newMapping indexes the name, description and attribute values as text and the traits and CIDs as keywords.
+func newMapping() mapping.IndexMapping {
+	text := bleve.NewTextFieldMapping()
+	keywords := bleve.NewKeywordFieldMapping()
+	keywords.Analyzer = keyword.Name
+	keywords.IncludeInAll = false
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("name", text)
+	doc.AddFieldMappingsAt("description", text)
+	doc.AddFieldMappingsAt("attributes", text)
+	doc.AddFieldMappingsAt("traits", keywords)
+	doc.AddFieldMappingsAt("cid", keywords)
+	doc.AddFieldMappingsAt("image_cid", keywords)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	return m
+}
+
+func newDocument(metadata ipfs.Metadata) document {
+	doc := document{
+		CID:         metadata.CID,
+		Name:        metadata.Name,
+		Description: metadata.Description,
+		Attributes:  []string{},
+		Traits:      []string{},
+		ImageCID:    ipfs.ImageCID(metadata.Image),
+	}
+	for _, attribute := range metadata.Attributes {
+		doc.Attributes = append(doc.Attributes, fmt.Sprint(attribute.Value))
+		doc.Traits = append(doc.Traits, backend.AttributeKey(attribute.TraitType, attribute.Value))
+	}
+
+	return doc
+}
+
+// Index adds or replaces the given Metadata in the index.
+func (i *Index) Index(metadata ...ipfs.Metadata) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	err := indexBatch(i.index, metadata)
+	if err != nil {
+		return err
+	}
+	// callers may reuse the slice
+	i.record(write{metadata: append([]ipfs.Metadata(nil), metadata...)})
+	return nil
+}
+
+func indexBatch(index bleve.Index, metadata []ipfs.Metadata) error {
+	batch := index.NewBatch()
+	for _, m := range metadata {
+		// negative records of CIDs that failed to fetch have nothing to search
+		if m.Error != "" {
+			continue
+		}
+		err := batch.Index(m.ID, newDocument(m))
+		if err != nil {
+			return err
+		}
+	}
+
+	return index.Batch(batch)
+}
+
+// Delete removes the record with the given ID from the index.
+func (i *Index) Delete(id string) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	err := i.index.Delete(id)
+	if err != nil {
+		return err
+	}
+	i.record(write{deleteID: id})
+	return nil
+}
+
+// record keeps w for replaying it on the new index if a rebuild runs.
+func (i *Index) record(w write) {
+	i.bufferMu.Lock()
+	defer i.bufferMu.Unlock()
+
+	if i.buffer != nil {
+		i.buffer = append(i.buffer, w)
+	}
+}
+
+// startBuffering starts recording writes for a rebuild, it reports false if a rebuild already records them.
+func (i *Index) startBuffering() bool {
+	i.bufferMu.Lock()
+	defer i.bufferMu.Unlock()
+
+	if i.buffer != nil {
+		return false
+	}
+	i.buffer = []write{}
+	return true
+}
+
+// stopBuffering stops recording writes and returns what was recorded.
+func (i *Index) stopBuffering() []write {
+	i.bufferMu.Lock()
+	defer i.bufferMu.Unlock()
+
+	recorded := i.buffer
+	i.buffer = nil
+	return recorded
+}
+
+// replay applies recorded writes to index in order.
+func replay(index bleve.Index, writes []write) error {
+	for _, w := range writes {
+		var err error
+		if w.deleteID != "" {
+			err = index.Delete(w.deleteID)
+		} else {
+			err = indexBatch(index, w.metadata)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Request is a search over the index.
+type Request struct {
+	// Query uses the bleve query string syntax, an empty query matches everything.
+	Query string
+	// Traits only matches records that have all of the given traits.
+	Traits map[string]any
+	// ImageCID only matches records whose image points to the given CID.
+	ImageCID string
+	// From and Size page through the results.
+	From int
+	Size int
+}
+
+// Hit is a single matching record.
+type Hit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Response is the result of a search.
+type Response struct {
+	Total uint64 `json:"total"`
+	Hits  []Hit  `json:"hits"`
+}
+
+// Search runs the request against the index.
+func (i *Index) Search(req Request) (Response, error) {
+	queries := []query.Query{}
+	if req.Query == "" {
+		queries = append(queries, bleve.NewMatchAllQuery())
+	} else {
+		queries = append(queries, bleve.NewQueryStringQuery(req.Query))
+	}
+	for trait, value := range req.Traits {
+		q := bleve.NewTermQuery(backend.AttributeKey(trait, value))
+		q.SetField("traits")
+		queries = append(queries, q)
+	}
+	if req.ImageCID != "" {
+		q := bleve.NewTermQuery(req.ImageCID)
+		q.SetField("image_cid")
+		queries = append(queries, q)
+	}
+
+	size := req.Size
+	if size <= 0 {
+		size = 10
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	result, err := i.index.Search(bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(queries...), size, req.From, false))
+	if err != nil {
+		return Response{}, err
+	}
+
+	response := Response{Total: result.Total, Hits: make([]Hit, 0, len(result.Hits))}
+	for _, hit := range result.Hits {
+		response.Hits = append(response.Hits, Hit{ID: hit.ID, Score: hit.Score})
+	}
+
+	return response, nil
+}
+
+// Rebuild regenerates the index from every Metadata record in the store, e.g. after the mapping changed.
+// The new index is built next to the current one and swapped in once complete, searches keep working meanwhile.
+// Records indexed or deleted during the rebuild are replayed on the new index before the swap, and if the swap
+// fails the current index is kept.
+func (i *Index) Rebuild(store *backend.Store[ipfs.Metadata]) (int, error) {
+	if !i.startBuffering() {
+		return 0, errors.New("search index rebuild already running")
+	}
+
+	rebuildPath := i.path + ".rebuild"
+	index, count, err := i.build(store, rebuildPath)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// the scan may have read older versions of the records written meanwhile, so their writes go last
+	writes := i.stopBuffering()
+	if err == nil {
+		err = replay(index, writes)
+	}
+	if index != nil {
+		closeErr := index.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = i.swap(rebuildPath)
+	}
+	if err != nil {
+		os.RemoveAll(rebuildPath)
+		return 0, err
+	}
+
+	i.logger.Infof("Rebuilt search index with %d records and %d writes made during the rebuild", count, len(writes))
+	return count, nil
+}
+
+// build indexes every Metadata record in the store into a new index at path and returns it open.
+func (i *Index) build(store *backend.Store[ipfs.Metadata], path string) (bleve.Index, int, error) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index, err := bleve.New(path, newMapping())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count := 0
+	batch := make([]ipfs.Metadata, 0, rebuildBatchSize)
+	err = store.Each(ipfs.MetadataIDPrefix, rebuildBatchSize, func(metadata ipfs.Metadata) error {
+		batch = append(batch, metadata)
+		if len(batch) < rebuildBatchSize {
+			return nil
+		}
+		count += len(batch)
+		err := indexBatch(index, batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil {
+		count += len(batch)
+		err = indexBatch(index, batch)
+	}
+
+	return index, count, err
+}
+
+// swap replaces the current index with the closed one at rebuildPath. If that fails the current index
+// is restored and reopened. i.mu must be held.
+func (i *Index) swap(rebuildPath string) error {
+	err := i.index.Close()
+	if err != nil {
+		return err
+	}
+
+	oldPath := i.path + ".old"
+	err = os.RemoveAll(oldPath)
+	if err == nil {
+		err = os.Rename(i.path, oldPath)
+	}
+	if err != nil {
+		return i.reopen(err)
+	}
+
+	err = os.Rename(rebuildPath, i.path)
+	if err != nil {
+		return i.restore(oldPath, err)
+	}
+
+	index, err := bleve.Open(i.path)
+	if err != nil {
+		os.RemoveAll(i.path)
+		return i.restore(oldPath, err)
+	}
+	i.index = index
+
+	err = os.RemoveAll(oldPath)
+	if err != nil {
+		i.logger.WithError(err).Warn("Failed to remove the replaced search index")
+	}
+	return nil
+}
+
+// restore moves the index at oldPath back in place after a failed swap, reopens it and returns err.
+func (i *Index) restore(oldPath string, err error) error {
+	renameErr := os.Rename(oldPath, i.path)
+	if renameErr != nil {
+		i.logger.WithError(renameErr).Error("Failed to restore the search index")
+	}
+	return i.reopen(err)
+}
+
+// reopen opens the index at path again after a failed swap and returns err.
+func (i *Index) reopen(err error) error {
+	index, openErr := bleve.Open(i.path)
+	if openErr != nil {
+		i.logger.WithError(openErr).Error("Failed to reopen the search index")
+		return err
+	}
+	i.index = index
+	return err
+}
+
+// Close closes the index.
+func (i *Index) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.index.Close()
+}
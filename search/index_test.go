@@ -0,0 +1,144 @@
+package search
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ipfs-scrape/worker/backend"
+	"github.com/ipfs-scrape/worker/ipfs"
+)
+
+func TestIndexSearch(t *testing.T) {
+	index, err := Open(filepath.Join(t.TempDir(), "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	err = index.Index(
+		ipfs.Metadata{
+			ID:          "d-gold",
+			Name:        "Golden Dragon",
+			Description: "A dragon made of gold",
+			Image:       "ipfs://image-a/1.png",
+			Attributes:  []ipfs.Attribute{{TraitType: "Background", Value: "Gold"}},
+		},
+		ipfs.Metadata{
+			ID:          "d-blue",
+			Name:        "Blue Dragon",
+			Description: "A dragon made of ice",
+			Image:       "ipfs://image-b/2.png",
+			Attributes:  []ipfs.Attribute{{TraitType: "Background", Value: "Blue"}},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		req      Request
+		expected []string
+	}{
+		{"text", Request{Query: "ice"}, []string{"d-blue"}},
+		{"trait", Request{Query: "dragon", Traits: map[string]any{"background": "gold"}}, []string{"d-gold"}},
+		{"image", Request{ImageCID: "image-b"}, []string{"d-blue"}},
+		{"page", Request{Query: "dragon", From: 1, Size: 1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := index.Search(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expected == nil {
+				if response.Total != 2 || len(response.Hits) != 1 {
+					t.Errorf("expected 1 of 2 hits, got %d of %d", len(response.Hits), response.Total)
+				}
+				return
+			}
+
+			if len(response.Hits) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, response.Hits)
+			}
+			for i, hit := range response.Hits {
+				if hit.ID != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected, response.Hits)
+				}
+			}
+		})
+	}
+}
+
+// scanBackend serves a single page of records and calls during while it is scanned.
+type scanBackend struct {
+	backend.Backend
+	records []any
+	during  func()
+	err     error
+}
+
+func (b scanBackend) ScanPage(prefix string, cursor string, limit int) ([]any, string, error) {
+	b.during()
+	return b.records, "", b.err
+}
+
+func TestIndexRebuild(t *testing.T) {
+	index, err := Open(filepath.Join(t.TempDir(), "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	err = index.Index(ipfs.Metadata{ID: "d-stale", Name: "Stale Dragon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func() []string {
+		response, err := index.Search(Request{Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, hit := range response.Hits {
+			ids = append(ids, hit.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	// a failed rebuild keeps the current index
+	_, err = index.Rebuild(backend.NewStore[ipfs.Metadata](scanBackend{during: func() {}, err: errors.New("scan failed")}))
+	if err == nil {
+		t.Fatal("expected the rebuild to fail")
+	}
+	if found := ids(); len(found) != 1 || found[0] != "d-stale" {
+		t.Errorf("expected the current index to be kept, got %v", found)
+	}
+
+	// records indexed during the rebuild make it into the new index
+	store := backend.NewStore[ipfs.Metadata](scanBackend{
+		records: []any{ipfs.Metadata{ID: "d-gold", Name: "Golden Dragon"}},
+		during: func() {
+			err := index.Index(ipfs.Metadata{ID: "d-new", Name: "New Dragon"})
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	count, err := index.Rebuild(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 rebuilt record, got %d", count)
+	}
+	if found := ids(); len(found) != 2 || found[0] != "d-gold" || found[1] != "d-new" {
+		t.Errorf("expected d-gold and d-new, got %v", found)
+	}
+}